@@ -0,0 +1,197 @@
+package http
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPins is the number of sessions kept pinned to an endpoint. Once there are more, the least recently used are
+	// released.
+	maxPins = 4096
+
+	// pinTTL is how long a session is kept pinned to an endpoint without requests, since sessions that are never
+	// ended with EndSession would otherwise stay pinned for as long as the client runs.
+	pinTTL = 30 * time.Minute
+)
+
+// AffinityKeyFunc extracts the session key from a request. Requests to the same service that share a session key
+// are all sent to the endpoint the first of them reached. An empty key means the request has no session.
+type AffinityKeyFunc = func(req *Request) string
+
+type (
+	affinityContextKey struct{}
+
+	pinsMapKey struct {
+		hostPort string
+		session  string
+	}
+
+	sessionPin struct {
+		resolvedHostPort string
+		lastUse          time.Time
+	}
+
+	// pinCache holds the endpoints sessions are pinned to, up to maxPins, releasing those unused for pinTTL.
+	pinCache struct {
+		pins map[pinsMapKey]*sessionPin
+		sync.Mutex
+	}
+)
+
+// AffinityCookie returns an AffinityKeyFunc that uses the value of the cookie with the given name as session key.
+func AffinityCookie(name string) AffinityKeyFunc {
+	return func(req *Request) string {
+		cookie, err := req.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return cookie.Value
+	}
+}
+
+// AffinityHeader returns an AffinityKeyFunc that uses the value of the header with the given name as session key.
+func AffinityHeader(name string) AffinityKeyFunc {
+	return func(req *Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// WithAffinityKey returns a copy of ctx that pins requests done with it to the endpoint the first request with the
+// same key reached, regardless of the affinity configured for the service.
+func WithAffinityKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, affinityContextKey{}, key)
+}
+
+// SetAffinity makes every request to the service at hostPort use keyFunc to find its session key. Passing a nil
+// keyFunc disables affinity for the service, without releasing existing pins. Like in Watch, the service can also be
+// given as a URL or a bare host.
+func (c *Client) SetAffinity(hostPort string, keyFunc AffinityKeyFunc) {
+	hostPort = c.serviceHostPort(hostPort)
+	if keyFunc == nil {
+		c.affinities.Delete(hostPort)
+		return
+	}
+	c.affinities.Store(hostPort, keyFunc)
+}
+
+// EndSession releases the endpoint pinned for the session with the given key on the service at hostPort. It should
+// be called when the session ends, so that the next session with the same key goes through archimedes again. Like in
+// Watch, the service can also be given as a URL or a bare host.
+func (c *Client) EndSession(hostPort, key string) {
+	hostPort = c.serviceHostPort(hostPort)
+	c.pins.delete(pinsMapKey{hostPort: hostPort, session: key})
+	c.logger().Debug("ended session", LogFieldHost, hostPort, "session", key)
+}
+
+func (c *Client) sessionKey(hostPort string, req *Request) string {
+	if key, ok := req.Context().Value(affinityContextKey{}).(string); ok && key != "" {
+		return key
+	}
+
+	value, ok := c.affinities.Load(hostPort)
+	if !ok {
+		return ""
+	}
+
+	return value.(AffinityKeyFunc)(req)
+}
+
+func (c *Client) loadPin(hostPort, session string) (resolvedHostPort string, ok bool) {
+	if session == "" {
+		return "", false
+	}
+
+	return c.pins.load(pinsMapKey{hostPort: hostPort, session: session}, time.Now())
+}
+
+// pin pins the session to resolvedHostPort, which must be an endpoint the service was resolved to.
+func (c *Client) pin(hostPort, session, resolvedHostPort string) {
+	if session == "" {
+		return
+	}
+
+	evicted := c.pins.store(pinsMapKey{hostPort: hostPort, session: session}, resolvedHostPort, time.Now())
+	c.logger().Debug("pinned session", LogFieldHost, hostPort, "session", session, LogFieldEndpoint,
+		resolvedHostPort)
+	if evicted > 0 {
+		c.logger().Debug("released least recently used pins", "count", evicted)
+	}
+}
+
+// load returns the endpoint the session of key is pinned to, if it was used within pinTTL of now.
+func (p *pinCache) load(key pinsMapKey, now time.Time) (resolvedHostPort string, ok bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	cached, ok := p.pins[key]
+	if !ok {
+		return "", false
+	}
+	if now.Sub(cached.lastUse) >= pinTTL {
+		delete(p.pins, key)
+		return "", false
+	}
+
+	cached.lastUse = now
+	return cached.resolvedHostPort, true
+}
+
+// store pins the session of key to resolvedHostPort, releasing the expired pins and then the least recently used ones
+// over maxPins. It returns how many pins were released to keep the bound.
+func (p *pinCache) store(key pinsMapKey, resolvedHostPort string, now time.Time) (evicted int) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.pins == nil {
+		p.pins = map[pinsMapKey]*sessionPin{}
+	}
+	p.pins[key] = &sessionPin{resolvedHostPort: resolvedHostPort, lastUse: now}
+
+	if len(p.pins) <= maxPins {
+		return 0
+	}
+
+	for key, cached := range p.pins {
+		if now.Sub(cached.lastUse) >= pinTTL {
+			delete(p.pins, key)
+			evicted++
+		}
+	}
+
+	for len(p.pins) > maxPins {
+		var (
+			oldestKey pinsMapKey
+			oldest    *sessionPin
+		)
+		for key, cached := range p.pins {
+			if oldest == nil || cached.lastUse.Before(oldest.lastUse) {
+				oldestKey, oldest = key, cached
+			}
+		}
+
+		delete(p.pins, oldestKey)
+		evicted++
+	}
+
+	return evicted
+}
+
+func (p *pinCache) delete(key pinsMapKey) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.pins, key)
+}
+
+// rangeUnexpired calls f with every pin used within pinTTL of now.
+func (p *pinCache) rangeUnexpired(now time.Time, f func(key pinsMapKey, resolvedHostPort string)) {
+	p.Lock()
+	defer p.Unlock()
+
+	for key, cached := range p.pins {
+		if now.Sub(cached.lastUse) < pinTTL {
+			f(key, cached.resolvedHostPort)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package http
+
+import (
+	originalHttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAffinityNormalizesHost(t *testing.T) {
+	c := &Client{}
+	c.SetAffinity("https://svc", AffinityHeader("X-Session"))
+
+	req, err := NewRequest("GET", "https://svc/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Session", "s1")
+
+	if got := c.sessionKey("svc:443", req); got != "s1" {
+		t.Fatalf("got session %q, want %q", got, "s1")
+	}
+	if got := c.sessionKey("svc:80", req); got != "" {
+		t.Errorf("got session %q for another port, want none", got)
+	}
+
+	c.pin("svc:443", "s1", "10.0.0.1:443")
+	if got, ok := c.loadPin("svc:443", "s1"); !ok || got != "10.0.0.1:443" {
+		t.Fatalf("got pin %q (%t), want %q", got, ok, "10.0.0.1:443")
+	}
+
+	c.EndSession("svc:443", "s1")
+	if got, ok := c.loadPin("svc:443", "s1"); ok {
+		t.Errorf("got pin %q after the session ended", got)
+	}
+
+	c.pin("svc:443", "s2", "10.0.0.1:443")
+	c.EndSession("https://svc", "s2")
+	if got, ok := c.loadPin("svc:443", "s2"); ok {
+		t.Errorf("got pin %q after the session ended by URL", got)
+	}
+
+	c.SetAffinity("https://svc", nil)
+	if got := c.sessionKey("svc:443", req); got != "" {
+		t.Errorf("got session %q after disabling affinity", got)
+	}
+}
+
+func TestPinsExpire(t *testing.T) {
+	pins := &pinCache{}
+	key := pinsMapKey{hostPort: "svc:80", session: "s1"}
+	now := time.Now()
+
+	pins.store(key, "10.0.0.1:80", now)
+	if got, ok := pins.load(key, now.Add(pinTTL-time.Second)); !ok || got != "10.0.0.1:80" {
+		t.Fatalf("got pin %q (%t) before it expired, want %q", got, ok, "10.0.0.1:80")
+	}
+
+	// using the pin keeps it for another pinTTL
+	if _, ok := pins.load(key, now.Add(2*pinTTL-2*time.Second)); !ok {
+		t.Fatal("pin used within pinTTL expired")
+	}
+	if got, ok := pins.load(key, now.Add(3*pinTTL)); ok {
+		t.Errorf("got pin %q after it expired", got)
+	}
+	if len(pins.pins) != 0 {
+		t.Errorf("kept %d expired pins", len(pins.pins))
+	}
+}
+
+func TestPinsAreBounded(t *testing.T) {
+	pins := &pinCache{}
+	key := func(i int) pinsMapKey {
+		return pinsMapKey{hostPort: "svc:80", session: strconv.Itoa(i)}
+	}
+	now := time.Now()
+
+	// an expired pin is released before any that is still in use
+	pins.store(key(-1), "10.0.0.1:80", now.Add(-pinTTL))
+	for i := 0; i < maxPins; i++ {
+		pins.store(key(i), "10.0.0.1:80", now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if len(pins.pins) != maxPins {
+		t.Fatalf("got %d pins, want %d", len(pins.pins), maxPins)
+	}
+	if _, ok := pins.pins[key(-1)]; ok {
+		t.Error("kept the expired pin")
+	}
+
+	// keep the first pin in use so that the second is the least recently used
+	pins.load(key(0), now.Add(maxPins*time.Millisecond))
+	if evicted := pins.store(key(maxPins), "10.0.0.1:80", now.Add((maxPins+1)*time.Millisecond)); evicted != 1 {
+		t.Errorf("released %d pins, want 1", evicted)
+	}
+	if len(pins.pins) != maxPins {
+		t.Errorf("got %d pins, want %d", len(pins.pins), maxPins)
+	}
+	if _, ok := pins.pins[key(1)]; ok {
+		t.Error("least recently used pin was kept")
+	}
+	if _, ok := pins.pins[key(0)]; !ok {
+		t.Error("recently used pin was released")
+	}
+}
+
+func TestOnlyResolvedEndpointsArePinned(t *testing.T) {
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer server.Close()
+
+	c := newFakeArchimedesClient(&fakeArchimedes{endpoints: map[string]string{"svc": server.Listener.Addr().String()}})
+	c.SetAffinity("svc", AffinityHeader("X-Session"))
+	c.SetAffinity("missing.invalid", AffinityHeader("X-Session"))
+
+	get := func(url string) error {
+		req, err := NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Session", "s1")
+
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	// archimedes does not know the service, so the request goes to the host as is and fails
+	if err := get("http://missing.invalid/"); err == nil {
+		t.Fatal("got no error for a service archimedes does not know")
+	}
+	if got, ok := c.loadPin("missing.invalid:80", "s1"); ok {
+		t.Errorf("pinned the session to %q, which archimedes did not resolve", got)
+	}
+
+	if err := get("http://svc/"); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := c.loadPin("svc:80", "s1"); !ok || got != server.Listener.Addr().String() {
+		t.Errorf("got pin %q (%t), want %q", got, ok, server.Listener.Addr().String())
+	}
+}
@@ -0,0 +1,171 @@
+package http
+
+import (
+	"context"
+)
+
+// ArchimedesTrace is a set of hooks called at the archimedes related stages of a request, like httptrace.ClientTrace
+// does for DNS, connections and TLS. Any particular hook may be nil. Hooks may be called concurrently from different
+// goroutines and some may be called after the request has completed or failed.
+//
+// A trace is attached to a request with WithArchimedesTrace. Events that do not belong to any request, like the cache
+// refresher evicting a stale entry or the client going back to its fallback archimedes server, are only reported to
+// the client's ArchimedesTrace, which is also called for every request after the trace in its context.
+type ArchimedesTrace struct {
+	// ResolveStart is called when the client starts finding the endpoint of a service, before looking in the cache.
+	ResolveStart func(ResolveStartInfo)
+
+	// ResolveDone is called once the endpoint of a service was found, whether it came from the cache or from
+	// archimedes, or finding it failed.
+	ResolveDone func(ResolveDoneInfo)
+
+	// CacheHit is called when the endpoint of a service is found in the cache or in a session pin.
+	CacheHit func(CacheHitInfo)
+
+	// StaleEntryEvicted is called when a cache entry that expired is removed from the cache.
+	StaleEntryEvicted func(StaleEntryEvictedInfo)
+
+	// FallbackSwitched is called when the client goes back to its fallback archimedes server.
+	FallbackSwitched func(FallbackSwitchedInfo)
+
+	// RetryingAfterCachedFailure is called when sending a request to a cached endpoint failed and the service is
+	// about to be resolved again in archimedes.
+	RetryingAfterCachedFailure func(RetryingAfterCachedFailureInfo)
+}
+
+// ResolveStartInfo is passed to ArchimedesTrace.ResolveStart.
+type ResolveStartInfo struct {
+	Host string
+}
+
+// ResolveDoneInfo is passed to ArchimedesTrace.ResolveDone. Cached tells whether the endpoint came from the cache or
+// a session pin instead of archimedes.
+type ResolveDoneInfo struct {
+	Host     string
+	Endpoint string
+	Status   ResolutionOutcome
+	Cached   bool
+	Err      error
+}
+
+// CacheHitInfo is passed to ArchimedesTrace.CacheHit.
+type CacheHitInfo struct {
+	Host     string
+	Endpoint string
+	Stale    bool
+	Pinned   bool
+}
+
+// StaleEntryEvictedInfo is passed to ArchimedesTrace.StaleEntryEvicted.
+type StaleEntryEvictedInfo struct {
+	Host     string
+	Endpoint string
+}
+
+// FallbackSwitchedInfo is passed to ArchimedesTrace.FallbackSwitched with the addresses of the archimedes server the
+// client was using and of its fallback.
+type FallbackSwitchedInfo struct {
+	From string
+	To   string
+}
+
+// RetryingAfterCachedFailureInfo is passed to ArchimedesTrace.RetryingAfterCachedFailure with the cached endpoint
+// that failed and why.
+type RetryingAfterCachedFailureInfo struct {
+	Host     string
+	Endpoint string
+	Err      error
+}
+
+type archimedesTraceContextKey struct{}
+
+// WithArchimedesTrace returns a copy of ctx that makes requests done with it call the hooks in trace. If ctx already
+// has a trace, both are called, the hooks in the new trace first.
+func WithArchimedesTrace(ctx context.Context, trace *ArchimedesTrace) context.Context {
+	if trace == nil {
+		panic("nil trace")
+	}
+
+	return context.WithValue(ctx, archimedesTraceContextKey{}, trace.compose(ContextArchimedesTrace(ctx)))
+}
+
+// ContextArchimedesTrace returns the ArchimedesTrace associated with ctx, or nil if there is none.
+func ContextArchimedesTrace(ctx context.Context) *ArchimedesTrace {
+	trace, _ := ctx.Value(archimedesTraceContextKey{}).(*ArchimedesTrace)
+	return trace
+}
+
+// archimedesTrace returns the hooks to call for a request done with ctx, which may be nil.
+func (c *Client) archimedesTrace(ctx context.Context) *ArchimedesTrace {
+	trace := ContextArchimedesTrace(ctx)
+	if trace == nil {
+		return c.ArchimedesTrace
+	}
+	return trace.compose(c.ArchimedesTrace)
+}
+
+// compose returns a trace that calls the hooks in t and then the ones in old.
+func (t *ArchimedesTrace) compose(old *ArchimedesTrace) *ArchimedesTrace {
+	if old == nil {
+		return t
+	}
+
+	return &ArchimedesTrace{
+		ResolveStart:               composeHook(t.ResolveStart, old.ResolveStart),
+		ResolveDone:                composeHook(t.ResolveDone, old.ResolveDone),
+		CacheHit:                   composeHook(t.CacheHit, old.CacheHit),
+		StaleEntryEvicted:          composeHook(t.StaleEntryEvicted, old.StaleEntryEvicted),
+		FallbackSwitched:           composeHook(t.FallbackSwitched, old.FallbackSwitched),
+		RetryingAfterCachedFailure: composeHook(t.RetryingAfterCachedFailure, old.RetryingAfterCachedFailure),
+	}
+}
+
+func composeHook[T any](hook, old func(T)) func(T) {
+	switch {
+	case hook == nil:
+		return old
+	case old == nil:
+		return hook
+	}
+
+	return func(info T) {
+		hook(info)
+		old(info)
+	}
+}
+
+func (t *ArchimedesTrace) resolveStart(info ResolveStartInfo) {
+	if t != nil && t.ResolveStart != nil {
+		t.ResolveStart(info)
+	}
+}
+
+func (t *ArchimedesTrace) resolveDone(info ResolveDoneInfo) {
+	if t != nil && t.ResolveDone != nil {
+		t.ResolveDone(info)
+	}
+}
+
+func (t *ArchimedesTrace) cacheHit(info CacheHitInfo) {
+	if t != nil && t.CacheHit != nil {
+		t.CacheHit(info)
+	}
+}
+
+func (t *ArchimedesTrace) staleEntryEvicted(info StaleEntryEvictedInfo) {
+	if t != nil && t.StaleEntryEvicted != nil {
+		t.StaleEntryEvicted(info)
+	}
+}
+
+func (t *ArchimedesTrace) fallbackSwitched(info FallbackSwitchedInfo) {
+	if t != nil && t.FallbackSwitched != nil {
+		t.FallbackSwitched(info)
+	}
+}
+
+func (t *ArchimedesTrace) retryingAfterCachedFailure(info RetryingAfterCachedFailureInfo) {
+	if t != nil && t.RetryingAfterCachedFailure != nil {
+		t.RetryingAfterCachedFailure(info)
+	}
+}
@@ -10,6 +10,7 @@
 package http
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -26,25 +27,35 @@ import (
 	"github.com/bruno-anjos/cloud-edge-deployment/pkg/archimedes/client"
 	"github.com/docker/go-connections/nat"
 	"github.com/golang/geo/s2"
-	"github.com/google/uuid"
-	log "github.com/sirupsen/logrus"
 )
 
 type (
 	cacheEntry struct {
-		stale    bool
-		resolved string
+		stale        bool
+		resolved     string
+		deploymentId string
+		protocol     string
+		createdAt    time.Time
+		// clientLocation tells whether the entry was resolved for the client's location rather than for the location
+		// of a request, so that only the client's entries follow it when it moves
+		clientLocation bool
 		sync.RWMutex
 	}
-	addressCacheKey   = string
+	addressCacheKey struct {
+		hostPort string
+		cell     s2.CellID
+	}
 	addressCacheValue = *cacheEntry
 )
 
-func newCacheEntry(resolved string) *cacheEntry {
+func newCacheEntry(resolved, deploymentId, protocol string) *cacheEntry {
 	return &cacheEntry{
-		stale:    false,
-		resolved: resolved,
-		RWMutex:  sync.RWMutex{},
+		stale:        false,
+		resolved:     resolved,
+		deploymentId: deploymentId,
+		protocol:     protocol,
+		createdAt:    time.Now(),
+		RWMutex:      sync.RWMutex{},
 	}
 }
 
@@ -73,15 +84,19 @@ const (
 	FallbackEnvVar         = "FALLBACK_URL"
 
 	DefaultArchimedesPort = 1500
-)
 
-type (
-	MiddlewareFunc = func(reqId string, req *Request)
+	// MaxArchimedesRedirects is the maximum number of times a resolution is delegated from an archimedes node to a
+	// closer archimedes node before giving up.
+	MaxArchimedesRedirects = 5
+)
 
-	middlewaresMapKey   = string
-	middlewaresMapValue = MiddlewareFunc
+var (
+	ErrArchimedesRedirectLoop     = errors.New("archimedes redirect loop detected")
+	ErrTooManyArchimedesRedirects = errors.New("too many archimedes redirects")
 )
 
+type MiddlewareFunc = func(reqId string, req *Request)
+
 // Client in order for this client to use archimedes properly, protocols that
 // use http for handshake and are stream oriented should behave in two possible
 // ways:
@@ -96,18 +111,119 @@ type (
 //	to reflect possible changes archimedes might received. The speed at which the
 //	connection is restarted is proportional to the freshness of the host url
 //	being used to access a given service.
+//
+// Stateful interactions that can not afford to lose server side state can use SetAffinity or WithAffinityKey to pin
+// a session to the endpoint it first reached, until either that endpoint fails, EndSession is called or the session
+// has no requests for 30 minutes. Watch tells stream oriented interactions when archimedes would now send them
+// somewhere else, so they know when to restart.
 type Client struct {
 	originalHttp.Client
-	cache             sync.Map
-	beforeMiddlewares sync.Map
-	afterMiddlewares  sync.Map
-	archimedesClient  *client.Client
-	fallbackAddr      string
-	location          s2.CellID
-	initialized       bool
+
+	// AdoptRedirectedArchimedes makes the client use the archimedes node that answered a redirected resolution as
+	// its primary archimedes server, so that subsequent resolutions go directly to the closer node. The primary
+	// server is still reset to the fallback periodically.
+	AdoptRedirectedArchimedes bool
+
+	// DeploymentIDFunc extracts the deployment id from the host of each request. If nil, DefaultDeploymentIDFunc is
+	// used. It can be overridden for a single request with WithDeploymentIDFunc.
+	DeploymentIDFunc DeploymentIDFunc
+
+	// SchemePorts overrides DefaultSchemePorts, the port used for URLs without an explicit port, by scheme.
+	SchemePorts map[string]string
+
+	// SchemeProtocols overrides DefaultSchemeProtocols, the transport protocol archimedes is asked to resolve ports
+	// for, by scheme.
+	SchemeProtocols map[string]string
+
+	// HostPolicy decides the Host header sent once the request URL is rewritten to the resolved endpoint.
+	HostPolicy HostPolicy
+
+	// ServiceHeader is the header the logical service host is sent in when using HostServiceHeader. If empty,
+	// DefaultServiceHeader is used.
+	ServiceHeader string
+
+	// Tracer enables tracing when set. Each Do call then produces a span with child spans for the cache lookup, the
+	// resolution in archimedes and the request to the resolved endpoint, whose trace context is sent upstream.
+	Tracer Tracer
+
+	// Metrics receives the client measurements (cache lookups, resolutions, archimedes server switches and
+	// requests). If nil, nothing is measured.
+	Metrics Metrics
+
+	// ArchimedesTrace receives the events that do not belong to any request, like stale cache entries being evicted,
+	// and the events of every request after the trace attached to it with WithArchimedesTrace, if any.
+	ArchimedesTrace *ArchimedesTrace
+
+	// LocationHeader is a header, like ClientLocationHeader, with the location of the user a request is done for, as
+	// "lat,lng" or an s2 cell token, for gateways and proxies doing requests on behalf of users elsewhere. Requests
+	// with it, or with a location set by WithLocation, are resolved for that location instead of the client's. The
+	// header must be set by a trusted party, so it is not read unless set here.
+	LocationHeader string
+
+	// LocationPrivacy limits how precisely archimedes learns the location of the client. If nil, archimedes is sent
+	// the leaf cell of the location.
+	LocationPrivacy *LocationPrivacy
+
+	// EndpointLocator tells where endpoints are, so that they can be checked against the geofences set with
+	// SetDeploymentGeofence and WithGeofence. Requests with a geofence fail if it is nil, since no endpoint can be
+	// known to be inside it.
+	EndpointLocator EndpointLocator
+
+	// GeofencePolicy decides whether services resolved to endpoints outside their geofence fail or are resolved again.
+	GeofencePolicy GeofencePolicy
+
+	// LocationDebounce is how long location updates from a LocationProvider are coalesced before the latest is used.
+	// If 0, DefaultLocationDebounce is used.
+	LocationDebounce time.Duration
+
+	// CellChangePolicy decides whether the cached resolutions are dropped or refreshed when the client moves to
+	// another cell of the cache.
+	CellChangePolicy CellChangePolicy
+
+	// CacheCellLevel is the level of the s2 cells the cache is partitioned by, so that requests for users in different
+	// cells do not share endpoints. If 0, DefaultCacheCellLevel is used.
+	CacheCellLevel int
+
+	// RequestIDHeader is the header each request's ID is sent to the service in. The same ID is passed to archimedes
+	// when resolving the service and logged by the client, so that the logs of the three can be joined. If empty,
+	// DefaultRequestIDHeader is used.
+	RequestIDHeader string
+
+	// Logger receives the client logs. If nil, they are written to the standard logrus logger.
+	Logger Logger
+
+	cache               sync.Map
+	affinities          sync.Map
+	pins                pinCache
+	deploymentsTLS      sync.Map
+	deploymentGeofences sync.Map
+	tlsTransports       tlsTransportCache
+	beforeMiddlewares   middlewareChain
+	afterMiddlewares    middlewareChain
+	responseMiddlewares middlewareChain
+	watchers            map[string]map[chan ResolutionChange]struct{}
+	watchersLock        sync.Mutex
+	resolutionErrors    recentResolutionErrors
+	archimedesClient    archimedesResolver
+	archimedesAddr      string
+	fallbackAddr        string
+	location            s2.CellID
+	initialized         bool
 	sync.RWMutex
 }
 
+// archimedesResolver is what the client uses to talk to an archimedes node.
+type archimedesResolver interface {
+	Resolve(host string, port nat.Port, deploymentId string, cLocation s2.CellID, reqId string) (rHost, rPort string,
+		status int, timedOut bool)
+	ChangeArchimedesAddr(addr string)
+}
+
+// newArchimedesClient returns the client used to talk to the archimedes node at addr.
+var newArchimedesClient = func(addr string) archimedesResolver {
+	return client.NewArchimedesClient(addr)
+}
+
 var ErrUseLastResponse = originalHttp.ErrUseLastResponse
 
 var DefaultClient = &Client{}
@@ -122,16 +238,18 @@ func Get(url string) (resp *Response, err error) {
 // archimedes server and the location where the user is at the moment.
 func (c *Client) InitArchimedesClient(host string, port int, location s2.LatLng) {
 	hostPort := host + ":" + strconv.Itoa(port)
-	log.Infof("Starting archimedes client with host %s", hostPort)
+	c.logger().Info("starting archimedes client", LogFieldArchimedes, hostPort)
 
 	c.Lock()
-	c.archimedesClient = client.NewArchimedesClient(hostPort)
+	c.archimedesClient = newArchimedesClient(hostPort)
+	c.archimedesAddr = hostPort
 	c.location = s2.CellIDFromLatLng(location)
 	c.initialized = true
 
 	fallbackAddr, exists := os.LookupEnv(FallbackEnvVar)
 	if !exists {
-		log.Panicf("could not load env var %s", FallbackEnvVar)
+		c.logger().Error("could not load fallback archimedes address", "env_var", FallbackEnvVar)
+		panic(fmt.Sprintf("could not load env var %s", FallbackEnvVar))
 	}
 
 	c.fallbackAddr = fallbackAddr
@@ -140,31 +258,60 @@ func (c *Client) InitArchimedesClient(host string, port int, location s2.LatLng)
 	go c.resetToFallbackPeriodically()
 }
 
+// SetLocation changes the location where the user is at. If it moved to another cell of the cache, the cached
+// resolutions of the old cell are dropped or refreshed, according to the client's CellChangePolicy. Services being
+// watched are resolved again for the new location when either that cell or the one archimedes is told about, at the
+// level of the cache, changed, so that small moves inside a cell do not resolve them again.
 func (c *Client) SetLocation(location s2.LatLng) {
 	c.Lock()
-	defer c.Unlock()
+	oldLocation := c.location
 	c.location = s2.CellIDFromLatLng(location)
+	newLocation := c.location
+	c.Unlock()
+
+	oldCell, newCell := c.cacheKey("", oldLocation).cell, c.cacheKey("", newLocation).cell
+	oldArchimedesCell := c.cacheKey("", c.archimedesLocation(oldLocation)).cell
+	newArchimedesCell := c.cacheKey("", c.archimedesLocation(newLocation)).cell
+	if oldCell == newCell && oldArchimedesCell == newArchimedesCell {
+		return
+	}
+
+	watched := c.watchedHosts()
+	oldResolved := make(map[string]string, len(watched))
+	for _, hostPort := range watched {
+		if value, ok := c.cache.Load(c.cacheKey(hostPort, oldLocation)); ok {
+			oldResolved[hostPort] = value.(addressCacheValue).getResolved()
+		}
+	}
+
+	if oldCell != newCell {
+		c.logger().Debug("moved to another cell", "cell", c.archimedesLocation(newLocation).ToToken())
+		c.leaveCell(oldCell)
+	}
+
+	for _, hostPort := range watched {
+		go c.reresolveWatched(hostPort, oldResolved[hostPort], ReasonLocationChanged)
+	}
 }
 
 // RegisterMiddleware registers a middleware with id midId and a function midFunc that is ran everytime a request
 // is done. If afterResolving is true the function is called with the resulting request after resolving the request url
 // through archimedes. If afterResolving is false the function is called with the original request.
 //
-// Even though midFunc receives a pointer to a request, it should only read fields from it and never change them,
-// since there are no guarantees on the order the different middlewares will be called.
-// The request is only passed as a pointer to avoid making a copy for each middleware.
-func (c *Client) RegisterMiddleware(midId string, midFunc MiddlewareFunc, afterResolving bool) {
-	var loaded bool
-	if afterResolving {
-		_, loaded = c.afterMiddlewares.LoadOrStore(midId, midFunc)
-	} else {
-		_, loaded = c.beforeMiddlewares.LoadOrStore(midId, midFunc)
+// midFunc is an observer: it is started in its own goroutine, in the position of the chain given by
+// DefaultMiddlewarePriority, and the request does not wait for it. midFunc receives a copy of the request as it was
+// at that point, so it may read it while the request goes on, but it should neither change it nor read its body,
+// which is shared with the request being sent. Middlewares that need to change the request should be registered with
+// RegisterChainMiddleware.
+//
+// If a middleware with the same id was already registered for the same phase it is replaced.
+func (c *Client) RegisterMiddleware(midId string, midFunc MiddlewareFunc, afterResolving bool) error {
+	if midFunc == nil {
+		return fmt.Errorf("%w: middleware %s has no function", ErrInvalidMiddleware, midId)
 	}
 
-	if loaded {
-		panic(fmt.Sprintf("error registering: middleware with id %s already exists", midId))
-	}
-	log.Debugf("registered middleware %s", midId)
+	mid := &middleware{id: midId, priority: DefaultMiddlewarePriority, observer: midFunc}
+	return c.registerMiddleware(mid, c.phase(afterResolving))
 }
 
 func (c *Client) Get(url string) (resp *Response, err error) {
@@ -177,41 +324,56 @@ func (c *Client) Get(url string) (resp *Response, err error) {
 
 func (c *Client) refreshCachePeriodically() {
 	cacheTicker := time.NewTicker(refreshCacheTimeout)
-	log.Debugf("setting up cache refreshing")
+	c.logger().Debug("setting up cache refreshing")
 
 	for {
 		<-cacheTicker.C
 
-		log.Debugf("refreshing cache")
+		c.logger().Debug("refreshing cache")
 
-		staleEntries := map[string]interface{}{}
+		staleEntries := map[addressCacheKey]string{}
 		c.cache.Range(func(key, value interface{}) bool {
-			hostPort := key.(addressCacheKey)
+			cacheKey := key.(addressCacheKey)
 			entry := value.(addressCacheValue)
 			if entry.isStale() {
-				log.Debugf("adding entry for %s as stale", hostPort)
-				staleEntries[hostPort] = nil
+				c.logger().Debug("dropping stale cache entry", LogFieldHost, cacheKey.hostPort, "cell",
+					cacheKey.cell.ToToken())
+				staleEntries[cacheKey] = entry.getResolved()
 			}
 			return true
 		})
 
-		for hostPort := range staleEntries {
-			c.cache.Delete(hostPort)
+		for cacheKey, oldResolved := range staleEntries {
+			c.cache.Delete(cacheKey)
+			c.ArchimedesTrace.staleEntryEvicted(StaleEntryEvictedInfo{Host: cacheKey.hostPort,
+				Endpoint: oldResolved})
+			if c.isWatched(cacheKey.hostPort) && cacheKey == c.ownCacheKey(cacheKey.hostPort) {
+				go c.reresolveWatched(cacheKey.hostPort, oldResolved, ReasonExpired)
+			}
 		}
 	}
 }
 
 func (c *Client) resetToFallbackPeriodically() {
 	fallbackTicker := time.NewTicker(ResetToFallbackTimeout)
-	log.Info("setting up fallback reset")
+	c.logger().Debug("setting up fallback reset")
 
 	for {
 		<-fallbackTicker.C
 
-		log.Infof("resetting to fallback %s", c.fallbackAddr)
+		c.logger().Info("resetting to fallback archimedes", LogFieldArchimedes, c.fallbackAddr)
+		fallbackAddr := c.fallbackAddr + ":" + strconv.Itoa(archimedes.Port)
 		c.Lock()
-		c.archimedesClient.ChangeArchimedesAddr(c.fallbackAddr + ":" + strconv.Itoa(archimedes.Port))
+		oldAddr := c.archimedesAddr
+		switched := oldAddr != fallbackAddr
+		c.archimedesAddr = fallbackAddr
+		c.archimedesClient.ChangeArchimedesAddr(c.archimedesAddr)
 		c.Unlock()
+
+		if switched {
+			c.metrics().ArchimedesServerSwitch(SwitchFallback)
+			c.ArchimedesTrace.fallbackSwitched(FallbackSwitchedInfo{From: oldAddr, To: fallbackAddr})
+		}
 	}
 }
 
@@ -221,157 +383,482 @@ const (
 	errTimeout2    = "Timeout"
 )
 
+// isEndpointFailure tells whether err means the endpoint a request was sent to could not be reached, in which case a
+// cached endpoint is worth resolving again. Any other error, like a redirect policy error, fails the request.
+func isEndpointFailure(err error) bool {
+	return strings.Contains(err.Error(), errConnRefused) || strings.Contains(err.Error(), errTimeout1) ||
+		strings.Contains(err.Error(), errTimeout2)
+}
+
 func (c *Client) Do(req *Request) (*Response, error) {
 	if !c.initialized {
 		panic("client has not been initialized")
 	}
 
-	reqId := uuid.New().String()
-	c.beforeMiddlewares.Range(func(key, value interface{}) bool {
-		midId := key.(middlewaresMapKey)
-		midFunc := key.(middlewaresMapValue)
-		log.Debugf("calling before middleware %s", midId)
-		go midFunc(reqId, req)
-		return true
-	})
+	start := time.Now()
+	req, reqId := c.withRequestID(req)
+	req = c.withHeaderLocation(req)
+	info := &RequestInfo{
+		ReqId:       reqId,
+		OriginalURL: req.URL,
+	}
+
+	var span Span
+	if c.tracingEnabled() {
+		cell := c.archimedesLocation(c.locationFor(req.Context())).ToToken()
+
+		var ctx context.Context
+		ctx, span = c.startSpan(req.Context(), SpanDo,
+			AttrHttpMethod, req.Method,
+			AttrUrl, req.URL.String(),
+			AttrCell, cell,
+		)
+		req = req.WithContext(ctx)
+	}
+
+	resp, err := c.do(req, info)
+
+	info.Err = err
+	if resp != nil {
+		info.StatusCode = resp.StatusCode
+	}
+	if span != nil {
+		endDoSpan(span, info)
+	}
+	c.metrics().Request(info.DeploymentId, info.StatusCode, time.Since(start))
+	c.runResponseMiddlewares(info, resp)
+
+	return resp, err
+}
 
-	hostPort := req.Host
+func (c *Client) do(req *Request, info *RequestInfo) (*Response, error) {
+	reqId := info.ReqId
+	endpoint, forced := endpointFromContext(req.Context())
+	deploymentIdFunc := c.deploymentIDFunc(req.Context())
+	if forced {
+		// requests to an explicit endpoint are not resolved, so their hosts need not belong to a deployment
+		deploymentIdFunc = optionalDeploymentID(deploymentIdFunc)
+	}
+	target, err := scopeTargetFor(logicalHost(req), deploymentIdFunc)
+	if err != nil {
+		return nil, err
+	}
+	info.DeploymentId = target.deploymentId
+	if resp, err := c.runMiddlewares(&c.beforeMiddlewares, target, reqId, req); resp != nil || err != nil {
+		return resp, err
+	}
+
+	hostPort, err := c.hostPortForRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	session := c.sessionKey(hostPort, req)
+	opts := c.resolveOptionsFor(req.Context(), req.URL.Scheme)
+	deploymentId, err := deploymentIdFor(deploymentIdFunc, hostWithoutPort(hostPort))
+	if err != nil {
+		return nil, err
+	}
+	archimedesTrace := c.archimedesTrace(req.Context())
 
 	var (
-		resolvedHostPort string
-		usingCache, ok   bool
-		err              error
-		found            bool
+		resolvedHostPort         string
+		usingCache, usingPin, ok bool
+		found                    bool
 	)
 
-	value, ok := c.cache.Load(hostPort)
-	if ok {
+	resolutionStart := time.Now()
+	archimedesTrace.resolveStart(ResolveStartInfo{Host: hostPort})
+	_, lookupSpan := c.startSpan(req.Context(), SpanCacheLookup)
+	cacheKey := c.cacheKey(hostPort, opts.location)
+	value, ok := c.cache.Load(cacheKey)
+	if forced {
+		resolvedHostPort = endpoint
+		session = ""
+		info.CacheStatus = CacheBypassed
+	} else if isLiteralHostPort(hostPort) {
+		resolvedHostPort = hostPort
+		session = ""
+		info.CacheStatus = CacheBypassed
+	} else if !opts.cacheable() {
+		session = ""
+		info.CacheStatus = CacheMiss
+	} else if resolvedHostPort, usingPin = c.loadPin(hostPort, session); usingPin {
+		archimedesTrace.cacheHit(CacheHitInfo{Host: hostPort, Endpoint: resolvedHostPort, Pinned: true})
+		c.logger().Debug("resolved using pin", LogFieldReqId, reqId, LogFieldHost, hostPort,
+			LogFieldEndpoint, resolvedHostPort, "session", session)
+		info.CacheStatus = CachePinned
+	} else if ok {
 		entry := value.(addressCacheValue)
 		resolvedHostPort = entry.getResolved()
-		log.Infof("resolved %s to %s using cache", hostPort, resolvedHostPort)
+		c.logger().Debug("resolved using cache", LogFieldReqId, reqId, LogFieldHost, hostPort,
+			LogFieldEndpoint, resolvedHostPort)
 		usingCache = true
+		info.CacheStatus = CacheHit
+		if entry.isStale() {
+			info.CacheStatus = CacheStaleHit
+		}
+		archimedesTrace.cacheHit(CacheHitInfo{Host: hostPort, Endpoint: resolvedHostPort,
+			Stale: info.CacheStatus == CacheStaleHit})
 	} else {
-		resolvedHostPort, found, err = c.ResolveServiceInArchimedes(hostPort)
+		info.CacheStatus = CacheMiss
+	}
+	lookupSpan.SetAttributes(AttrCacheStatus, info.CacheStatus.String())
+	lookupSpan.End(nil)
+	c.metrics().CacheLookup(info.CacheStatus)
+
+	if info.CacheStatus == CacheMiss {
+		resolvedHostPort, found, err = c.resolveServiceInArchimedes(req.Context(), hostPort, opts)
 		if err != nil {
-			panic(err)
+			archimedesTrace.resolveDone(ResolveDoneInfo{Host: hostPort, Status: ResolutionError, Err: err})
+			return nil, err
 		}
 
 		if !found {
-			log.Infof("could not resolve %s", hostPort)
+			c.logger().Debug("could not resolve", LogFieldReqId, reqId, LogFieldHost, hostPort)
+		}
+	}
+
+	// endpoints archimedes did not resolve the service to are not pinned, so that the next request tries again
+	resolved := found || usingCache || usingPin
+	geofences := c.geofencesFor(req.Context(), deploymentId)
+	if len(geofences) > 0 {
+		allowedHostPort, err := c.enforceGeofences(req.Context(), geofences, deploymentId, hostPort,
+			resolvedHostPort, opts)
+		if err != nil {
+			archimedesTrace.resolveDone(ResolveDoneInfo{Host: hostPort, Endpoint: resolvedHostPort,
+				Status: ResolutionError, Err: err})
+			return nil, err
+		}
+
+		if allowedHostPort != resolvedHostPort {
+			resolvedHostPort = allowedHostPort
+			usingCache, usingPin = false, false
+			info.CacheStatus = CacheMiss
 		}
 	}
 
+	if resolved && !usingPin {
+		c.pin(hostPort, session, resolvedHostPort)
+	}
+	archimedesTrace.resolveDone(ResolveDoneInfo{
+		Host:     hostPort,
+		Endpoint: resolvedHostPort,
+		Status:   resolutionOutcome(found || info.CacheStatus != CacheMiss, nil),
+		Cached:   usingCache || usingPin,
+	})
+	info.ResolutionLatency = time.Since(resolutionStart)
+
+	logical := logicalHost(req)
 	oldUrl := req.URL
 	newUrl := *oldUrl
 	newUrl.Host = resolvedHostPort
 
 	req.URL = &newUrl
+	info.ResolvedURL = &newUrl
+	c.applyHostPolicy(req, logical, resolvedHostPort)
 
-	c.afterMiddlewares.Range(func(key, value interface{}) bool {
-		midId := key.(middlewaresMapKey)
-		midFunc := key.(middlewaresMapValue)
-		log.Debugf("calling after middleware %s", midId)
-		go midFunc(reqId, req)
-		return true
-	})
-
-	resp, err := c.Client.Do(req)
-	if err != nil && usingCache {
-		failed := false
+	info.DeploymentId = deploymentId
+	target = scopeTarget{host: hostWithoutPort(logical), deploymentId: info.DeploymentId}
+	if resp, err := c.runMiddlewares(&c.afterMiddlewares, target, reqId, req); resp != nil || err != nil {
+		return resp, err
+	}
 
-		if strings.Contains(err.Error(), errConnRefused) || strings.Contains(err.Error(), errTimeout1) ||
-			strings.Contains(err.Error(), errTimeout2) {
-			failed = true
+	hops := newRequestHops(opts)
+	hops.add(resolvedHostPort, logical, deploymentId)
+	httpClient := c.httpClientFor(hops)
+
+	upstreamStart := time.Now()
+	resp, err := c.sendUpstream(httpClient, req)
+	info.UpstreamLatency = time.Since(upstreamStart)
+	if err != nil && (usingCache || usingPin) && isEndpointFailure(err) {
+		resolutionStart = time.Now()
+		failedHostPort := resolvedHostPort
+		c.logger().Debug("cached endpoint failed, resolving again", LogFieldReqId, reqId,
+			LogFieldDeployment, info.DeploymentId, LogFieldHost, hostPort, LogFieldEndpoint, resolvedHostPort,
+			LogFieldError, err)
+		c.cache.Delete(cacheKey)
+		if usingPin {
+			c.EndSession(hostPort, session)
+		}
+		c.metrics().Reresolution(info.DeploymentId)
+		archimedesTrace.retryingAfterCachedFailure(RetryingAfterCachedFailureInfo{Host: hostPort,
+			Endpoint: failedHostPort, Err: err})
+
+		archimedesTrace.resolveStart(ResolveStartInfo{Host: hostPort})
+		resolvedHostPort, found, err = c.resolveServiceInArchimedes(req.Context(), hostPort, opts)
+		archimedesTrace.resolveDone(ResolveDoneInfo{Host: hostPort, Endpoint: resolvedHostPort,
+			Status: resolutionOutcome(found, err), Err: err})
+		if err != nil {
+			return nil, err
 		}
 
-		if failed {
-			log.Debugf("got timeout using cached addr %s, will refresh cache entry", resolvedHostPort)
-			c.cache.Delete(hostPort)
+		if !found {
+			c.logger().Debug("could not resolve", LogFieldReqId, reqId, LogFieldHost, hostPort)
+		}
 
-			resolvedHostPort, found, err = c.ResolveServiceInArchimedes(hostPort)
+		if len(geofences) > 0 {
+			resolvedHostPort, err = c.enforceGeofences(req.Context(), geofences, deploymentId, hostPort,
+				resolvedHostPort, opts)
 			if err != nil {
-				panic(err)
-			}
-
-			if !found {
-				log.Infof("could not resolve %s", hostPort)
+				return nil, err
 			}
+		}
 
-			newUrl.Host = resolvedHostPort
-			req.URL = &newUrl
-			resp, err = c.Client.Do(req)
-		} else {
-			log.Panic(err.Error())
+		if found {
+			c.pin(hostPort, session, resolvedHostPort)
 		}
+		c.notifyResolutionChange(hostPort, failedHostPort, resolvedHostPort, ReasonFailover)
+		hops.add(resolvedHostPort, logical, deploymentId)
+		newUrl.Host = resolvedHostPort
+		req.URL = &newUrl
+		c.applyHostPolicy(req, logical, resolvedHostPort)
+		info.ResolutionLatency += time.Since(resolutionStart)
+		info.CacheStatus = CacheMiss
+
+		upstreamStart = time.Now()
+		resp, err = c.sendUpstream(httpClient, req)
+		info.UpstreamLatency += time.Since(upstreamStart)
 	}
 
+	c.mapLocationToLogical(resp, hops)
+
 	return resp, err
 }
 
+// sendUpstream sends the already resolved request, in a span of its own when tracing is enabled.
+func (c *Client) sendUpstream(httpClient *originalHttp.Client, req *Request) (*Response, error) {
+	ctx, span := c.startSpan(req.Context(), SpanUpstream, AttrEndpoint, req.URL.Host)
+	c.injectTraceContext(ctx, req)
+
+	resp, err := httpClient.Do(req)
+	if resp != nil {
+		span.SetAttributes(AttrHttpStatusCode, resp.StatusCode)
+	}
+	span.End(err)
+
+	return resp, err
+}
+
+// resolveOptions are the per request settings used to resolve a service in archimedes. A zero resolutionTimeout means
+// no timeout.
+type resolveOptions struct {
+	protocol          string
+	deploymentIdFunc  DeploymentIDFunc
+	noCache           bool
+	location          s2.CellID
+	requestLocation   bool
+	resolutionTimeout time.Duration
+}
+
+func (c *Client) resolveOptionsFor(ctx context.Context, scheme string) *resolveOptions {
+	_, requestLocation := cellFromContext(ctx)
+	return &resolveOptions{
+		protocol:          c.protocolForScheme(scheme),
+		deploymentIdFunc:  c.deploymentIDFunc(ctx),
+		noCache:           noCacheFromContext(ctx),
+		location:          c.locationFor(ctx),
+		requestLocation:   requestLocation,
+		resolutionTimeout: resolutionTimeoutFromContext(ctx),
+	}
+}
+
+// resolveOptionsForEntry returns the options to resolve again the service of a cache entry, for the client's
+// location.
+func (c *Client) resolveOptionsForEntry(entry *cacheEntry) *resolveOptions {
+	opts := c.resolveOptionsFor(context.Background(), "")
+	opts.deploymentIdFunc = fixedDeploymentID(entry.deploymentId)
+	opts.protocol = entry.protocol
+	return opts
+}
+
+// cacheable tells whether resolutions with these options may be looked up in and stored to the cache.
+func (o *resolveOptions) cacheable() bool {
+	return !o.noCache
+}
+
 // TODO ARCHIMEDES HTTP CLIENT CHANGED THIS METHOD
 func (c *Client) ResolveServiceInArchimedes(hostPort string) (resolvedHostPort string, found bool, err error) {
+	return c.resolveServiceInArchimedes(context.Background(), hostPort,
+		c.resolveOptionsFor(context.Background(), ""))
+}
+
+func (c *Client) resolveServiceInArchimedes(ctx context.Context, hostPort string, opts *resolveOptions) (
+	resolvedHostPort string, found bool, err error) {
+	resolutionStart := time.Now()
+	ctx, span := c.startSpan(ctx, SpanResolve)
+	defer func() {
+		span.SetAttributes(AttrEndpoint, resolvedHostPort)
+		span.End(err)
+
+		c.metrics().Resolution(resolutionOutcome(found, err), time.Since(resolutionStart))
+		if err != nil {
+			c.resolutionErrors.add(hostPort, err)
+		}
+	}()
+
 	host, rawPort, err := net.SplitHostPort(hostPort)
 	if err != nil {
-		log.Error("hostport: ", hostPort)
-		panic(err)
+		return "", false, err
 	}
 
-	ip := net.ParseIP(host)
-	if ip != nil {
-		resolvedHostPort = hostPort
-		found = true
-		return resolvedHostPort, found, nil
+	if net.ParseIP(host) != nil {
+		return hostPort, true, nil
 	}
 
-	port := nat.Port(rawPort + "/tcp")
+	port, err := newPort(rawPort, opts.protocol)
+	if err != nil {
+		return "", false, err
+	}
 
-	deploymentId := strings.Split(host, "-")[0]
-	start := time.Now()
-	reqId, err := uuid.NewUUID()
+	deploymentId, err := opts.deploymentIdFunc(host)
 	if err != nil {
-		panic(err)
+		return "", false, err
 	}
 
+	start := time.Now()
+	reqId := requestIDFor(ctx)
+
+	c.RLock()
+	archimedesAddr := c.archimedesAddr
+	c.RUnlock()
+	location := c.archimedesLocation(opts.location)
+
+	if opts.resolutionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.resolutionTimeout)
+		defer cancel()
+	}
+
+	span.SetAttributes(AttrDeploymentId, deploymentId, AttrArchimedesServer, archimedesAddr,
+		AttrCell, location.ToToken())
+
 	var (
 		rHost, rPort string
-		timedout     bool
 		status       int
+		peerClient   archimedesResolver
 	)
-	for {
-		c.RLock()
-		rHost, rPort, status, timedout = c.archimedesClient.Resolve(host, port, deploymentId, c.location,
-			reqId.String())
-		c.RUnlock()
-		if !timedout {
+
+	visited := map[string]struct{}{archimedesAddr: {}}
+	for hops := 0; ; hops++ {
+		rHost, rPort, status, err = c.resolveInArchimedesNode(ctx, peerClient, host, port, deploymentId, location,
+			reqId)
+		if err != nil {
+			return "", false, err
+		}
+		if status != StatusSeeOther {
 			break
-		} else {
-			log.Warn("timed out on request to %s:%d for deployment %s", host, port.Port(), deploymentId)
-			time.Sleep(2 * time.Second)
 		}
+
+		peerAddr := rHost + ":" + rPort
+		if hops >= MaxArchimedesRedirects {
+			return "", false, fmt.Errorf("%w: resolving %s (req %s)", ErrTooManyArchimedesRedirects, hostPort,
+				reqId)
+		}
+
+		if _, ok := visited[peerAddr]; ok {
+			return "", false, fmt.Errorf("%w: resolving %s got redirected back to %s (req %s)",
+				ErrArchimedesRedirectLoop, hostPort, peerAddr, reqId)
+		}
+
+		c.logger().Debug("archimedes redirected resolution", LogFieldReqId, reqId,
+			LogFieldDeployment, deploymentId, LogFieldArchimedes, archimedesAddr, "peer", peerAddr)
+		span.AddEvent(EventRedirected, AttrArchimedesServer, peerAddr)
+		visited[peerAddr] = struct{}{}
+		archimedesAddr = peerAddr
+		peerClient = newArchimedesClient(peerAddr)
 	}
 
 	switch status {
-	case StatusSeeOther:
 	case StatusNotFound:
 		return hostPort, false, nil
 	case StatusOK:
-		log.Debugf("took %d to resolve %s", time.Since(start).Milliseconds(), reqId.String())
 	default:
 		return "", false,
 			errors.New(fmt.Sprintf("got status %d while resolving %s in archimedes (req %s took %f)",
-				status, hostPort, reqId.String(), time.Since(start).Seconds()))
+				status, hostPort, reqId, time.Since(start).Seconds()))
 	}
 
-	resolvedHostPort = rHost + ":" + rPort
-	log.Infof("resolved %s to %s in archimedes", hostPort, resolvedHostPort)
+	if peerClient != nil && c.AdoptRedirectedArchimedes {
+		c.logger().Info("adopting archimedes as primary archimedes server", LogFieldArchimedes, archimedesAddr)
+		span.AddEvent(EventAdopted, AttrArchimedesServer, archimedesAddr)
+		c.Lock()
+		c.archimedesAddr = archimedesAddr
+		c.archimedesClient.ChangeArchimedesAddr(archimedesAddr)
+		c.Unlock()
+		c.metrics().ArchimedesServerSwitch(SwitchAdopted)
+	}
 
-	entry := newCacheEntry(resolvedHostPort)
-	c.cache.Store(hostPort, entry)
-	go waitAndSetValueAsStale(entry)
+	resolvedHostPort = rHost + ":" + rPort
+	c.logger().Debug("resolved in archimedes", LogFieldReqId, reqId, LogFieldDeployment, deploymentId,
+		LogFieldHost, hostPort, LogFieldEndpoint, resolvedHostPort, "latency", time.Since(start))
+
+	if opts.cacheable() {
+		entry := newCacheEntry(resolvedHostPort, deploymentId, port.Proto())
+		entry.clientLocation = !opts.requestLocation
+		c.cache.Store(c.cacheKey(hostPort, opts.location), entry)
+		go waitAndSetValueAsStale(entry)
+	}
 
 	return resolvedHostPort, true, nil
 }
 
+// resolveInArchimedesNode asks a single archimedes node to resolve the given service, retrying while the node times
+// out, until ctx is done. If peerClient is nil the client's primary archimedes server is used.
+func (c *Client) resolveInArchimedesNode(ctx context.Context, peerClient archimedesResolver, host string, port nat.Port,
+	deploymentId string, location s2.CellID, reqId string) (rHost, rPort string, status int, err error) {
+	type resolution struct {
+		rHost, rPort string
+		status       int
+		timedout     bool
+	}
+
+	resolve := func() (r resolution) {
+		if peerClient == nil {
+			c.RLock()
+			r.rHost, r.rPort, r.status, r.timedout = c.archimedesClient.Resolve(host, port, deploymentId, location,
+				reqId)
+			c.RUnlock()
+		} else {
+			r.rHost, r.rPort, r.status, r.timedout = peerClient.Resolve(host, port, deploymentId, location, reqId)
+		}
+		return r
+	}
+
+	hostPort := host + ":" + port.Port()
+	for {
+		var r resolution
+		if ctx.Done() == nil {
+			r = resolve()
+		} else {
+			resolutions := make(chan resolution, 1)
+			go func() {
+				resolutions <- resolve()
+			}()
+
+			select {
+			case r = <-resolutions:
+			case <-ctx.Done():
+				return "", "", 0, resolutionContextError(ctx, hostPort)
+			}
+		}
+
+		if !r.timedout {
+			return r.rHost, r.rPort, r.status, nil
+		}
+
+		c.logger().Warn("archimedes timed out, retrying", LogFieldReqId, reqId, LogFieldDeployment, deploymentId,
+			LogFieldHost, hostPort)
+		spanFromContext(ctx).AddEvent(EventRetry)
+
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return "", "", 0, resolutionContextError(ctx, hostPort)
+		}
+	}
+}
+
 // Post issues a POST to the specified URL.
 //
 // Caller should close resp.Body when done reading from it.
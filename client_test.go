@@ -0,0 +1,169 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	originalHttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/golang/geo/s2"
+)
+
+// withArchimedesPeers makes the archimedes nodes the client is redirected to be the ones returned by peer, until the
+// test ends.
+func withArchimedesPeers(t *testing.T, peer func(addr string) archimedesResolver) {
+	oldNewArchimedesClient := newArchimedesClient
+	newArchimedesClient = peer
+	t.Cleanup(func() { newArchimedesClient = oldNewArchimedesClient })
+}
+
+func TestDoResolvesAndCaches(t *testing.T) {
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer server.Close()
+
+	archimedes := &fakeArchimedes{endpoints: map[string]string{"svc": server.Listener.Addr().String()}}
+	c := newFakeArchimedesClient(archimedes)
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get("http://svc/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+
+		if resp.Request.URL.Host != server.Listener.Addr().String() {
+			t.Errorf("request %d sent to %s, want %s", i, resp.Request.URL.Host, server.Listener.Addr().String())
+		}
+	}
+
+	resolutions := archimedes.resolved()
+	if len(resolutions) != 1 {
+		t.Fatalf("got %d resolutions, want 1", len(resolutions))
+	}
+	if resolution := resolutions[0]; resolution.host != "svc" || resolution.port.Port() != "80" ||
+		resolution.deploymentId != "svc" || resolution.reqId == "" {
+		t.Errorf("got resolution %+v", resolution)
+	}
+}
+
+func TestDoArchimedesRedirectErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		peer    func(addr string) archimedesResolver
+		wantErr error
+	}{
+		{
+			name: "loop",
+			peer: func(addr string) archimedesResolver {
+				return &fakeArchimedes{redirectTo: "archimedes:1500"}
+			},
+			wantErr: ErrArchimedesRedirectLoop,
+		},
+		{
+			name: "too many",
+			peer: func(addr string) archimedesResolver {
+				var peer int
+				_, _ = fmt.Sscanf(addr, "peer%d:1500", &peer)
+				return &fakeArchimedes{redirectTo: fmt.Sprintf("peer%d:1500", peer+1)}
+			},
+			wantErr: ErrTooManyArchimedesRedirects,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			withArchimedesPeers(t, test.peer)
+			c := newFakeArchimedesClient(&fakeArchimedes{redirectTo: "peer0:1500"})
+
+			if _, err := c.Get("http://svc/"); !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+// archimedesNetwork routes the resolutions of the archimedes clients it returns to the fake node at the address each
+// of them is using, so that changing that address changes the node resolutions go to.
+type archimedesNetwork map[string]*fakeArchimedes
+
+type archimedesNetworkClient struct {
+	network archimedesNetwork
+	addr    string
+	sync.Mutex
+}
+
+func (n archimedesNetwork) client(addr string) archimedesResolver {
+	return &archimedesNetworkClient{network: n, addr: addr}
+}
+
+func (c *archimedesNetworkClient) Resolve(host string, port nat.Port, deploymentId string, cLocation s2.CellID,
+	reqId string) (rHost, rPort string, status int, timedOut bool) {
+	c.Lock()
+	node := c.network[c.addr]
+	c.Unlock()
+	return node.Resolve(host, port, deploymentId, cLocation, reqId)
+}
+
+func (c *archimedesNetworkClient) ChangeArchimedesAddr(addr string) {
+	c.Lock()
+	defer c.Unlock()
+	c.addr = addr
+}
+
+func TestAdoptRedirectedArchimedes(t *testing.T) {
+	tests := []struct {
+		name     string
+		adopt    bool
+		wantAddr string
+	}{
+		{name: "adopt", adopt: true, wantAddr: "peer:1500"},
+		{name: "keep", adopt: false, wantAddr: "archimedes:1500"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoints := map[string]string{"svc": "10.0.0.1:8080", "other-svc": "10.0.0.2:8080"}
+			network := archimedesNetwork{
+				"archimedes:1500": {redirectTo: "peer:1500"},
+				"peer:1500":       {endpoints: endpoints},
+			}
+			withArchimedesPeers(t, network.client)
+
+			c := newFakeArchimedesClient(nil)
+			c.archimedesClient = network.client("archimedes:1500")
+			c.AdoptRedirectedArchimedes = test.adopt
+
+			for _, host := range []string{"svc", "other-svc"} {
+				resolved, found, err := c.ResolveServiceInArchimedes(host + ":80")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !found || resolved != endpoints[host] {
+					t.Errorf("got %s (%t) for %s, want %s", resolved, found, host, endpoints[host])
+				}
+			}
+
+			c.RLock()
+			addr := c.archimedesAddr
+			c.RUnlock()
+			if addr != test.wantAddr {
+				t.Errorf("got archimedes server %s, want %s", addr, test.wantAddr)
+			}
+
+			// once adopted, the peer is asked directly instead of through a redirect
+			wantRedirected := 2
+			if test.adopt {
+				wantRedirected = 1
+			}
+			if got := len(network["archimedes:1500"].resolved()); got != wantRedirected {
+				t.Errorf("got %d resolutions redirected, want %d", got, wantRedirected)
+			}
+			if got := len(network["peer:1500"].resolved()); got != 2 {
+				t.Errorf("got %d resolutions by the peer, want 2", got)
+			}
+		})
+	}
+}
@@ -15,6 +15,9 @@ import (
 type Cookie = originalHttp.Cookie
 type SameSite = originalHttp.SameSite
 
+// A CookieJar manages storage and use of cookies in HTTP requests.
+type CookieJar = originalHttp.CookieJar
+
 // SameSite allows a server to define a cookie attribute making it impossible for
 // the browser to send this cookie along with cross-site requests. The main
 // goal is to mitigate the risk of cross-origin information leakage, and provide
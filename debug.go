@@ -0,0 +1,306 @@
+package http
+
+import (
+	"encoding/json"
+	"html/template"
+	"mime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// maxRecentResolutionErrors is how many of the last resolution errors are kept to be shown by DebugHandler.
+const maxRecentResolutionErrors = 32
+
+// DebugState is a snapshot of the state of a Client, as shown by DebugHandler. It has no circuit breaker states
+// because the client has no circuit breakers: an endpoint that refuses connections or times out is resolved again
+// on the request that failed, instead of being kept open or half-open for later requests.
+type DebugState struct {
+	Initialized      bool   `json:"initialized"`
+	ArchimedesServer string `json:"archimedes_server"`
+	FallbackServer   string `json:"fallback_server"`
+
+	// Location is the center of Cell, the cell archimedes is told the client is at, which is only as precise as the
+	// client's LocationPrivacy allows.
+	Location DebugLocation `json:"location"`
+	Cell     string        `json:"cell"`
+
+	Cache        []DebugCacheEntry      `json:"cache"`
+	Pins         []DebugPin             `json:"pins"`
+	Watched      []string               `json:"watched"`
+	Middlewares  []DebugMiddleware      `json:"middlewares"`
+	RecentErrors []DebugResolutionError `json:"recent_errors"`
+}
+
+type DebugLocation struct {
+	Lat float64 `json:"lat"`
+	Lng float64 `json:"lng"`
+}
+
+// DebugCacheEntry is an address in the cache of the client. Cell is the cell the address was resolved for, no finer
+// than the cell archimedes is told the client is at, and empty if the client's LocationPrivacy moves its location.
+type DebugCacheEntry struct {
+	Host         string  `json:"host"`
+	Cell         string  `json:"cell,omitempty"`
+	Resolved     string  `json:"resolved"`
+	DeploymentId string  `json:"deployment_id"`
+	Protocol     string  `json:"protocol"`
+	Stale        bool    `json:"stale"`
+	AgeSeconds   float64 `json:"age_seconds"`
+}
+
+// DebugPin tells how many sessions of a service are pinned to an endpoint. Session keys are not shown, since they
+// usually come from cookies.
+type DebugPin struct {
+	Host     string `json:"host"`
+	Resolved string `json:"resolved"`
+	Sessions int    `json:"sessions"`
+}
+
+type DebugMiddleware struct {
+	Id       string           `json:"id"`
+	Phase    string           `json:"phase"`
+	Kind     string           `json:"kind"`
+	Priority int              `json:"priority"`
+	Scope    *MiddlewareScope `json:"scope,omitempty"`
+}
+
+type DebugResolutionError struct {
+	Time  time.Time `json:"time"`
+	Host  string    `json:"host"`
+	Error string    `json:"error"`
+}
+
+// recentResolutionErrors keeps the last maxRecentResolutionErrors resolution errors, oldest first.
+type recentResolutionErrors struct {
+	errors []DebugResolutionError
+	sync.Mutex
+}
+
+func (r *recentResolutionErrors) add(hostPort string, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.errors = append(r.errors, DebugResolutionError{Time: time.Now(), Host: hostPort, Error: err.Error()})
+	if len(r.errors) > maxRecentResolutionErrors {
+		r.errors = r.errors[len(r.errors)-maxRecentResolutionErrors:]
+	}
+}
+
+func (r *recentResolutionErrors) snapshot() []DebugResolutionError {
+	r.Lock()
+	defer r.Unlock()
+
+	return append([]DebugResolutionError{}, r.errors...)
+}
+
+// DebugState returns a snapshot of the state of the client: the archimedes servers it uses, where it is, what it has
+// cached and pinned, its middlewares and the last errors resolving services.
+func (c *Client) DebugState() DebugState {
+	c.RLock()
+	state := DebugState{
+		Initialized:      c.initialized,
+		ArchimedesServer: c.archimedesAddr,
+		FallbackServer:   c.fallbackAddr,
+	}
+	location := c.location
+	c.RUnlock()
+
+	cell := c.archimedesLocation(location)
+	state.Cell = cell.ToToken()
+	center := cell.LatLng()
+
+	state.Cache = []DebugCacheEntry{}
+	state.Pins = []DebugPin{}
+	state.Middlewares = []DebugMiddleware{}
+
+	state.Location = DebugLocation{Lat: center.Lat.Degrees(), Lng: center.Lng.Degrees()}
+
+	now := time.Now()
+	c.cache.Range(func(key, value interface{}) bool {
+		cacheKey := key.(addressCacheKey)
+		entry := value.(addressCacheValue)
+		entry.RLock()
+		state.Cache = append(state.Cache, DebugCacheEntry{
+			Host:         cacheKey.hostPort,
+			Cell:         c.debugCell(cacheKey.cell),
+			Resolved:     entry.resolved,
+			DeploymentId: entry.deploymentId,
+			Protocol:     entry.protocol,
+			Stale:        entry.stale,
+			AgeSeconds:   now.Sub(entry.createdAt).Seconds(),
+		})
+		entry.RUnlock()
+		return true
+	})
+	sort.Slice(state.Cache, func(i, j int) bool {
+		if state.Cache[i].Host != state.Cache[j].Host {
+			return state.Cache[i].Host < state.Cache[j].Host
+		}
+		return state.Cache[i].Cell < state.Cache[j].Cell
+	})
+
+	sessions := map[DebugPin]int{}
+	c.pins.rangeUnexpired(now, func(key pinsMapKey, resolvedHostPort string) {
+		sessions[DebugPin{Host: key.hostPort, Resolved: resolvedHostPort}]++
+	})
+	for pin, count := range sessions {
+		pin.Sessions = count
+		state.Pins = append(state.Pins, pin)
+	}
+	sort.Slice(state.Pins, func(i, j int) bool {
+		if state.Pins[i].Host != state.Pins[j].Host {
+			return state.Pins[i].Host < state.Pins[j].Host
+		}
+		return state.Pins[i].Resolved < state.Pins[j].Resolved
+	})
+
+	state.Watched = c.watchedHosts()
+	sort.Strings(state.Watched)
+
+	phases := []struct {
+		name  string
+		chain *middlewareChain
+	}{
+		{name: "before", chain: &c.beforeMiddlewares},
+		{name: "after", chain: &c.afterMiddlewares},
+		{name: "response", chain: &c.responseMiddlewares},
+	}
+	for _, phase := range phases {
+		for _, mid := range phase.chain.snapshot() {
+			state.Middlewares = append(state.Middlewares, DebugMiddleware{
+				Id:       mid.id,
+				Phase:    phase.name,
+				Kind:     mid.kind(),
+				Priority: mid.priority,
+				Scope:    mid.scope,
+			})
+		}
+	}
+
+	state.RecentErrors = c.resolutionErrors.snapshot()
+
+	return state
+}
+
+// debugCell returns the token of a cell of the cache, truncated to the level of the client's LocationPrivacy, so that
+// the debug state does not show where the client is more precisely than archimedes learns it. Cells of a client whose
+// location is moved by LocationPrivacy are not shown at all, since even a truncated cell would undo the jitter.
+func (c *Client) debugCell(cell s2.CellID) string {
+	privacy := c.LocationPrivacy
+	if privacy == nil {
+		return cell.ToToken()
+	}
+	if privacy.Level == 0 || privacy.MaxJitterMeters > 0 || !cell.IsValid() {
+		return ""
+	}
+
+	if cell.Level() > privacy.Level {
+		cell = cell.Parent(privacy.Level)
+	}
+	return cell.ToToken()
+}
+
+// DebugHandler returns a Handler that serves the DebugState of c, to be mounted next to net/http/pprof, e.g.
+//
+//	mux.Handle("/debug/archimedes", client.DebugHandler())
+//
+// The state is served as JSON if the request has format=json in its query or accepts application/json, and as an
+// HTML page otherwise.
+func (c *Client) DebugHandler() Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		state := c.DebugState()
+
+		if wantsJSON(r) {
+			w.Header().Set("Content-Type", "application/json")
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(state); err != nil {
+				c.logger().Warn("error writing debug state", LogFieldError, err)
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := debugTemplate.Execute(w, state); err != nil {
+			c.logger().Warn("error writing debug state", LogFieldError, err)
+		}
+	})
+}
+
+func wantsJSON(r *Request) bool {
+	if r.URL.Query().Get("format") == "json" {
+		return true
+	}
+
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+var debugTemplate = template.Must(template.New("debug").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>archimedes client</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>archimedes client</h1>
+<p><a href="?format=json">JSON</a></p>
+
+<h2>Archimedes</h2>
+<table>
+<tr><th>initialized</th><td>{{.Initialized}}</td></tr>
+<tr><th>server</th><td>{{.ArchimedesServer}}</td></tr>
+<tr><th>fallback</th><td>{{.FallbackServer}}</td></tr>
+<tr><th>location</th><td>{{printf "%.6f" .Location.Lat}}, {{printf "%.6f" .Location.Lng}}</td></tr>
+<tr><th>cell</th><td>{{.Cell}}</td></tr>
+</table>
+
+<h2>Cache</h2>
+<table>
+<tr><th>host</th><th>cell</th><th>resolved</th><th>deployment</th><th>protocol</th><th>stale</th><th>age (s)</th></tr>
+{{range .Cache}}<tr><td>{{.Host}}</td><td>{{.Cell}}</td><td>{{.Resolved}}</td><td>{{.DeploymentId}}</td><td>{{.Protocol}}</td><td>{{.Stale}}</td><td>{{printf "%.1f" .AgeSeconds}}</td></tr>
+{{else}}<tr><td colspan="7">empty</td></tr>
+{{end}}</table>
+
+<h2>Pinned sessions</h2>
+<table>
+<tr><th>host</th><th>resolved</th><th>sessions</th></tr>
+{{range .Pins}}<tr><td>{{.Host}}</td><td>{{.Resolved}}</td><td>{{.Sessions}}</td></tr>
+{{else}}<tr><td colspan="3">none</td></tr>
+{{end}}</table>
+
+<h2>Watched</h2>
+<ul>
+{{range .Watched}}<li>{{.}}</li>
+{{else}}<li>none</li>
+{{end}}</ul>
+
+<h2>Middlewares</h2>
+<table>
+<tr><th>phase</th><th>priority</th><th>id</th><th>kind</th><th>scope</th></tr>
+{{range .Middlewares}}<tr><td>{{.Phase}}</td><td>{{.Priority}}</td><td>{{.Id}}</td><td>{{.Kind}}</td><td>{{with .Scope}}{{.DeploymentIds}} {{.HostPattern}}{{else}}all{{end}}</td></tr>
+{{else}}<tr><td colspan="5">none</td></tr>
+{{end}}</table>
+
+<h2>Recent resolution errors</h2>
+<table>
+<tr><th>time</th><th>host</th><th>error</th></tr>
+{{range .RecentErrors}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Host}}</td><td>{{.Error}}</td></tr>
+{{else}}<tr><td colspan="3">none</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,214 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestDebugStateCacheCellPrivacy(t *testing.T) {
+	location := s2.LatLngFromDegrees(38.7369, -9.1427)
+	cacheCell := s2.CellIDFromLatLng(location).Parent(DefaultCacheCellLevel)
+
+	tests := []struct {
+		name    string
+		privacy *LocationPrivacy
+		want    string
+	}{
+		{name: "no privacy", want: cacheCell.ToToken()},
+		{name: "coarser privacy", privacy: &LocationPrivacy{Level: 10}, want: cacheCell.Parent(10).ToToken()},
+		{name: "finer privacy", privacy: &LocationPrivacy{Level: 20}, want: cacheCell.ToToken()},
+		{name: "no privacy level", privacy: &LocationPrivacy{MinPopulation: 1000}, want: ""},
+		{name: "jitter", privacy: &LocationPrivacy{Level: 10, MaxJitterMeters: 1000}, want: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeArchimedesClient(&fakeArchimedes{endpoints: map[string]string{"svc": "10.0.0.1:8080"}})
+			c.LocationPrivacy = test.privacy
+			c.SetLocation(location)
+
+			if _, _, err := c.ResolveServiceInArchimedes("svc:80"); err != nil {
+				t.Fatal(err)
+			}
+
+			cache := c.DebugState().Cache
+			if len(cache) != 1 {
+				t.Fatalf("got cache %+v, want one entry", cache)
+			}
+			if cache[0].Cell != test.want {
+				t.Errorf("got cell %q, want %q", cache[0].Cell, test.want)
+			}
+		})
+	}
+}
+
+// newDebugTestClient returns a client with two services in its cache, pinned sessions, a watched service, a
+// middleware and a resolution error.
+func newDebugTestClient(t *testing.T) *Client {
+	c := newFakeArchimedesClient(&fakeArchimedes{endpoints: map[string]string{
+		"svc":       "10.0.0.1:8080",
+		"other-svc": "10.0.0.2:8080",
+	}})
+	c.SetLocation(s2.LatLngFromDegrees(38.7369, -9.1427))
+
+	for _, hostPort := range []string{"svc:80", "other-svc:80"} {
+		if _, _, err := c.ResolveServiceInArchimedes(hostPort); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := c.ResolveServiceInArchimedes("svc"); err == nil {
+		t.Fatal("resolved a host without port")
+	}
+
+	c.pin("svc:80", "s1", "10.0.0.1:8080")
+	c.pin("svc:80", "s2", "10.0.0.1:8080")
+	c.pin("other-svc:80", "s1", "10.0.0.2:8080")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	c.Watch(ctx, "svc")
+
+	err := c.RegisterChainMiddleware("auth", 10, func(string, *Request) (*Response, error) {
+		return nil, nil
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestDebugState(t *testing.T) {
+	c := newDebugTestClient(t)
+	state := c.DebugState()
+
+	if !state.Initialized || state.ArchimedesServer != "archimedes:1500" || state.FallbackServer != "archimedes" {
+		t.Errorf("got archimedes %t %s %s", state.Initialized, state.ArchimedesServer, state.FallbackServer)
+	}
+	if want := s2.CellIDFromLatLng(s2.LatLngFromDegrees(38.7369, -9.1427)).ToToken(); state.Cell != want {
+		t.Errorf("got cell %s, want %s", state.Cell, want)
+	}
+	if math.Abs(state.Location.Lat-38.7369) > 1e-4 || math.Abs(state.Location.Lng+9.1427) > 1e-4 {
+		t.Errorf("got location %+v", state.Location)
+	}
+
+	if len(state.Cache) != 2 {
+		t.Fatalf("got cache %+v, want 2 entries", state.Cache)
+	}
+	for i, want := range []DebugCacheEntry{
+		{Host: "other-svc:80", Resolved: "10.0.0.2:8080", DeploymentId: "other", Protocol: "tcp"},
+		{Host: "svc:80", Resolved: "10.0.0.1:8080", DeploymentId: "svc", Protocol: "tcp"},
+	} {
+		got := state.Cache[i]
+		if got.Host != want.Host || got.Resolved != want.Resolved || got.DeploymentId != want.DeploymentId ||
+			got.Protocol != want.Protocol || got.Stale || got.Cell == "" || got.AgeSeconds < 0 {
+			t.Errorf("got cache entry %+v, want %+v", got, want)
+		}
+	}
+
+	wantPins := []DebugPin{
+		{Host: "other-svc:80", Resolved: "10.0.0.2:8080", Sessions: 1},
+		{Host: "svc:80", Resolved: "10.0.0.1:8080", Sessions: 2},
+	}
+	if !reflect.DeepEqual(state.Pins, wantPins) {
+		t.Errorf("got pins %+v, want %+v", state.Pins, wantPins)
+	}
+
+	if !reflect.DeepEqual(state.Watched, []string{"svc:80"}) {
+		t.Errorf("got watched %v, want svc:80", state.Watched)
+	}
+
+	wantMiddlewares := []DebugMiddleware{{Id: "auth", Phase: "before", Kind: "chain", Priority: 10}}
+	if !reflect.DeepEqual(state.Middlewares, wantMiddlewares) {
+		t.Errorf("got middlewares %+v, want %+v", state.Middlewares, wantMiddlewares)
+	}
+
+	if len(state.RecentErrors) != 1 || state.RecentErrors[0].Host != "svc" || state.RecentErrors[0].Error == "" {
+		t.Errorf("got errors %+v, want the one resolving svc", state.RecentErrors)
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	c := newDebugTestClient(t)
+
+	tests := []struct {
+		name     string
+		target   string
+		accept   string
+		wantJSON bool
+	}{
+		{name: "html", target: "/debug/archimedes"},
+		{name: "format", target: "/debug/archimedes?format=json", wantJSON: true},
+		{name: "accept", target: "/debug/archimedes", accept: "text/html;q=0.9, application/json", wantJSON: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.target, nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			recorder := httptest.NewRecorder()
+			c.DebugHandler().ServeHTTP(recorder, req)
+
+			contentType := recorder.Header().Get("Content-Type")
+			body := recorder.Body.String()
+			if !test.wantJSON {
+				if !strings.HasPrefix(contentType, "text/html") {
+					t.Errorf("got content type %s, want html", contentType)
+				}
+				for _, want := range []string{"<td>svc:80</td>", "<td>10.0.0.2:8080</td>", "<td>auth</td>"} {
+					if !strings.Contains(body, want) {
+						t.Errorf("page has no %s", want)
+					}
+				}
+				return
+			}
+
+			if contentType != "application/json" {
+				t.Errorf("got content type %s, want application/json", contentType)
+			}
+
+			var shape map[string]json.RawMessage
+			if err := json.Unmarshal(recorder.Body.Bytes(), &shape); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for key := range shape {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			wantKeys := "archimedes_server,cache,cell,fallback_server,initialized,location,middlewares,pins," +
+				"recent_errors,watched"
+			if got := strings.Join(keys, ","); got != wantKeys {
+				t.Errorf("got keys %s, want %s", got, wantKeys)
+			}
+
+			var entries []map[string]interface{}
+			if err := json.Unmarshal(shape["cache"], &entries); err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 || entries[1]["host"] != "svc:80" || entries[1]["resolved"] != "10.0.0.1:8080" ||
+				entries[1]["deployment_id"] != "svc" || entries[1]["stale"] != false {
+				t.Errorf("got cache %v", entries)
+			}
+
+			var state DebugState
+			if err := json.Unmarshal(recorder.Body.Bytes(), &state); err != nil {
+				t.Fatal(err)
+			}
+			if want := c.DebugState(); !reflect.DeepEqual(state.Pins, want.Pins) ||
+				!reflect.DeepEqual(state.Watched, want.Watched) || state.Cell != want.Cell {
+				t.Errorf("got state %+v, want %+v", state, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// DeploymentIDFunc extracts the id of the deployment a host (without port) belongs to, which is what archimedes
+// uses to resolve it.
+type DeploymentIDFunc = func(host string) (string, error)
+
+type deploymentIDFuncContextKey struct{}
+
+var ErrNoDeploymentID = errors.New("could not extract deployment id")
+
+// DefaultDeploymentIDFunc is the DeploymentIDFunc used by clients that do not set one. It takes everything before the
+// first hyphen as the deployment id.
+var DefaultDeploymentIDFunc = DeploymentIDPrefix("-")
+
+// DeploymentIDPrefix returns a DeploymentIDFunc that takes everything before the first occurrence of sep in the host
+// as the deployment id.
+func DeploymentIDPrefix(sep string) DeploymentIDFunc {
+	return func(host string) (string, error) {
+		deploymentId := strings.Split(host, sep)[0]
+		if deploymentId == "" {
+			return "", fmt.Errorf("%w: %s has nothing before %q", ErrNoDeploymentID, host, sep)
+		}
+		return deploymentId, nil
+	}
+}
+
+// DeploymentIDRegexp returns a DeploymentIDFunc that takes the first capture group of re as the deployment id, or
+// the whole match if re has no capture groups.
+func DeploymentIDRegexp(re *regexp.Regexp) DeploymentIDFunc {
+	return func(host string) (string, error) {
+		matches := re.FindStringSubmatch(host)
+		if matches == nil {
+			return "", fmt.Errorf("%w: %s does not match %s", ErrNoDeploymentID, host, re)
+		}
+
+		if len(matches) > 1 {
+			return matches[1], nil
+		}
+		return matches[0], nil
+	}
+}
+
+// DeploymentIDLabel returns a DeploymentIDFunc that takes the label at index of a DNS-style host as the deployment
+// id. Negative indexes count from the end, e.g. for svc.deployment.edge both 1 and -2 give deployment.
+func DeploymentIDLabel(index int) DeploymentIDFunc {
+	return func(host string) (string, error) {
+		labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+
+		i := index
+		if i < 0 {
+			i += len(labels)
+		}
+
+		if i < 0 || i >= len(labels) || labels[i] == "" {
+			return "", fmt.Errorf("%w: %s has no label %d", ErrNoDeploymentID, host, index)
+		}
+		return labels[i], nil
+	}
+}
+
+// DeploymentIDMap returns a DeploymentIDFunc that looks up the deployment id of each host in deploymentIds. Requests
+// to hosts that are not in it fail with ErrNoDeploymentID.
+func DeploymentIDMap(deploymentIds map[string]string) DeploymentIDFunc {
+	return func(host string) (string, error) {
+		deploymentId, ok := deploymentIds[host]
+		if !ok {
+			return "", fmt.Errorf("%w: %s is not mapped to any deployment", ErrNoDeploymentID, host)
+		}
+		return deploymentId, nil
+	}
+}
+
+// WithDeploymentIDFunc returns a copy of ctx that makes requests done with it use deploymentIdFunc instead of the
+// client's DeploymentIDFunc.
+func WithDeploymentIDFunc(ctx context.Context, deploymentIdFunc DeploymentIDFunc) context.Context {
+	return context.WithValue(ctx, deploymentIDFuncContextKey{}, deploymentIdFunc)
+}
+
+// WithDeploymentID returns a copy of ctx that makes requests done with it resolve services as deploymentId, whatever
+// their host is.
+func WithDeploymentID(ctx context.Context, deploymentId string) context.Context {
+	return WithDeploymentIDFunc(ctx, fixedDeploymentID(deploymentId))
+}
+
+func (c *Client) deploymentIDFunc(ctx context.Context) DeploymentIDFunc {
+	if deploymentIdFunc, ok := ctx.Value(deploymentIDFuncContextKey{}).(DeploymentIDFunc); ok &&
+		deploymentIdFunc != nil {
+		return deploymentIdFunc
+	}
+
+	if c.DeploymentIDFunc != nil {
+		return c.DeploymentIDFunc
+	}
+	return DefaultDeploymentIDFunc
+}
+
+// deploymentIdFor returns the deployment id of the service at host, a host without port, failing if deploymentIdFunc
+// can not extract it. IP addresses are not services, so they belong to no deployment.
+func deploymentIdFor(deploymentIdFunc DeploymentIDFunc, host string) (string, error) {
+	if net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")) != nil {
+		return "", nil
+	}
+	return deploymentIdFunc(host)
+}
+
+// optionalDeploymentID returns a DeploymentIDFunc that gives hosts deploymentIdFunc can not extract a deployment id
+// from no deployment id, instead of failing.
+func optionalDeploymentID(deploymentIdFunc DeploymentIDFunc) DeploymentIDFunc {
+	return func(host string) (string, error) {
+		deploymentId, err := deploymentIdFunc(host)
+		if err != nil {
+			return "", nil
+		}
+		return deploymentId, nil
+	}
+}
+
+func fixedDeploymentID(deploymentId string) DeploymentIDFunc {
+	return func(string) (string, error) {
+		return deploymentId, nil
+	}
+}
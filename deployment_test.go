@@ -0,0 +1,103 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestDeploymentIDFuncs(t *testing.T) {
+	tests := []struct {
+		name             string
+		deploymentIdFunc DeploymentIDFunc
+		host             string
+		want             string
+		wantErr          bool
+	}{
+		{name: "default", deploymentIdFunc: DefaultDeploymentIDFunc, host: "dep-svc", want: "dep"},
+		{name: "default without separator", deploymentIdFunc: DefaultDeploymentIDFunc, host: "dep", want: "dep"},
+		{name: "default empty prefix", deploymentIdFunc: DefaultDeploymentIDFunc, host: "-svc", wantErr: true},
+		{name: "prefix", deploymentIdFunc: DeploymentIDPrefix("."), host: "dep.svc.edge", want: "dep"},
+		{
+			name:             "regexp group",
+			deploymentIdFunc: DeploymentIDRegexp(regexp.MustCompile(`^svc\.([a-z]+)\.edge$`)),
+			host:             "svc.dep.edge",
+			want:             "dep",
+		},
+		{
+			name:             "regexp match",
+			deploymentIdFunc: DeploymentIDRegexp(regexp.MustCompile(`^[a-z]+`)),
+			host:             "dep1",
+			want:             "dep",
+		},
+		{
+			name:             "regexp no match",
+			deploymentIdFunc: DeploymentIDRegexp(regexp.MustCompile(`^svc\.([a-z]+)\.edge$`)),
+			host:             "other",
+			wantErr:          true,
+		},
+		{name: "label", deploymentIdFunc: DeploymentIDLabel(1), host: "svc.dep.edge", want: "dep"},
+		{name: "label from end", deploymentIdFunc: DeploymentIDLabel(-2), host: "svc.dep.edge.", want: "dep"},
+		{name: "label out of range", deploymentIdFunc: DeploymentIDLabel(3), host: "svc.dep.edge", wantErr: true},
+		{name: "label empty", deploymentIdFunc: DeploymentIDLabel(1), host: "svc..edge", wantErr: true},
+		{name: "map", deploymentIdFunc: DeploymentIDMap(map[string]string{"known": "dep"}), host: "known", want: "dep"},
+		{
+			name:             "map unknown",
+			deploymentIdFunc: DeploymentIDMap(map[string]string{"known": "dep"}),
+			host:             "unknown",
+			wantErr:          true,
+		},
+		{name: "fixed", deploymentIdFunc: fixedDeploymentID("dep"), host: "anything", want: "dep"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.deploymentIdFunc(test.host)
+			if test.wantErr {
+				if !errors.Is(err, ErrNoDeploymentID) {
+					t.Fatalf("got %q, %v, want ErrNoDeploymentID", got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDeploymentIdForIPs(t *testing.T) {
+	unmapped := DeploymentIDMap(map[string]string{})
+	for _, host := range []string{"10.0.0.1", "::1", "[::1]"} {
+		deploymentId, err := deploymentIdFor(unmapped, host)
+		if err != nil || deploymentId != "" {
+			t.Errorf("%s: got %q, %v, want no deployment", host, deploymentId, err)
+		}
+	}
+
+	if _, err := deploymentIdFor(unmapped, "svc"); !errors.Is(err, ErrNoDeploymentID) {
+		t.Errorf("svc: got %v, want ErrNoDeploymentID", err)
+	}
+}
+
+func TestDoUnmappedHost(t *testing.T) {
+	c := &Client{DeploymentIDFunc: DeploymentIDMap(map[string]string{"known": "d"}), initialized: true}
+
+	if _, err := c.Get("http://unknown/"); !errors.Is(err, ErrNoDeploymentID) {
+		t.Errorf("got %v, want ErrNoDeploymentID", err)
+	}
+
+	ctx := WithDeploymentID(context.Background(), "d")
+	req, err := NewRequestWithContext(ctx, "GET", "http://unknown/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Do(req.WithContext(WithEndpoint(ctx, "127.0.0.1:1"))); errors.Is(err, ErrNoDeploymentID) {
+		t.Errorf("got %v with a fixed deployment id", err)
+	}
+}
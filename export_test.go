@@ -0,0 +1,16 @@
+package http
+
+// NewFakeArchimedesClient returns an initialized client whose archimedes resolves the hosts in endpoints, given
+// without port, to the host:port they map to, for the tests of this package that can not reach its internals.
+func NewFakeArchimedesClient(endpoints map[string]string) *Client {
+	return newFakeArchimedesClient(&fakeArchimedes{endpoints: endpoints})
+}
+
+// SetFakeArchimedesTimeOut makes the archimedes of a client returned by NewFakeArchimedesClient time out on every
+// resolution, or stop doing so.
+func SetFakeArchimedesTimeOut(c *Client, timeOut bool) {
+	archimedes := c.archimedesClient.(*fakeArchimedes)
+	archimedes.Lock()
+	defer archimedes.Unlock()
+	archimedes.timeOut = timeOut
+}
@@ -0,0 +1,79 @@
+package http
+
+import (
+	"net"
+	"sync"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/golang/geo/s2"
+)
+
+// fakeArchimedes stands in for an archimedes node, resolving the hosts in endpoints (without port) to the endpoint
+// host:port they map to and any other host to not found. If redirectTo is set it redirects every resolution to the
+// archimedes node at that address instead and if timeOut is set every resolution times out.
+type fakeArchimedes struct {
+	endpoints  map[string]string
+	redirectTo string
+	timeOut    bool
+
+	addr        string
+	resolutions []fakeResolution
+	sync.Mutex
+}
+
+type fakeResolution struct {
+	host         string
+	port         nat.Port
+	deploymentId string
+	location     s2.CellID
+	reqId        string
+}
+
+func (f *fakeArchimedes) Resolve(host string, port nat.Port, deploymentId string, cLocation s2.CellID,
+	reqId string) (rHost, rPort string, status int, timedOut bool) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.resolutions = append(f.resolutions, fakeResolution{host: host, port: port, deploymentId: deploymentId,
+		location: cLocation, reqId: reqId})
+
+	if f.timeOut {
+		return "", "", 0, true
+	}
+
+	if f.redirectTo != "" {
+		rHost, rPort, _ = net.SplitHostPort(f.redirectTo)
+		return rHost, rPort, StatusSeeOther, false
+	}
+
+	endpoint, ok := f.endpoints[host]
+	if !ok {
+		return "", "", StatusNotFound, false
+	}
+
+	rHost, rPort, _ = net.SplitHostPort(endpoint)
+	return rHost, rPort, StatusOK, false
+}
+
+func (f *fakeArchimedes) ChangeArchimedesAddr(addr string) {
+	f.Lock()
+	defer f.Unlock()
+	f.addr = addr
+}
+
+func (f *fakeArchimedes) resolved() []fakeResolution {
+	f.Lock()
+	defer f.Unlock()
+	return append([]fakeResolution{}, f.resolutions...)
+}
+
+// newFakeArchimedesClient returns an initialized client that resolves services with archimedes, without starting
+// the cache refresh and fallback reset loops.
+func newFakeArchimedesClient(archimedes *fakeArchimedes) *Client {
+	return &Client{
+		archimedesClient: archimedes,
+		archimedesAddr:   "archimedes:1500",
+		fallbackAddr:     "archimedes",
+		initialized:      true,
+	}
+}
@@ -0,0 +1,297 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+var (
+	ErrOutsideGeofence         = errors.New("endpoint is outside the geofence")
+	ErrEndpointLocationUnknown = errors.New("endpoint location is unknown")
+	ErrInvalidGeoJSON          = errors.New("invalid GeoJSON")
+)
+
+// EndpointLocator tells where the endpoint, a host:port, that archimedes resolved a service of deploymentId to is.
+// It is what geofences are checked against.
+type EndpointLocator = func(deploymentId, endpoint string) (s2.LatLng, error)
+
+// GeofencePolicy decides what happens when a service is resolved to an endpoint outside its geofence.
+type GeofencePolicy int
+
+const (
+	// GeofenceReject fails the request with a GeofenceError.
+	GeofenceReject GeofencePolicy = iota
+	// GeofenceReresolve resolves the service again, as if the client was at the center of the geofence, and only
+	// fails the request if the new endpoint is still outside. The new endpoint is not cached.
+	GeofenceReresolve
+)
+
+// GeofenceAction is what the client did about an endpoint outside a geofence, as counted by Metrics.
+type GeofenceAction string
+
+const (
+	GeofenceRejected   GeofenceAction = "rejected"
+	GeofenceReresolved GeofenceAction = "reresolved"
+)
+
+// GeofenceError is returned when a request is not sent because the endpoint its service was resolved to is outside
+// the geofence of the request or deployment, or its location is unknown. Err is ErrOutsideGeofence or
+// ErrEndpointLocationUnknown.
+type GeofenceError struct {
+	DeploymentId string
+	Host         string
+	Endpoint     string
+	Err          error
+}
+
+func (e *GeofenceError) Error() string {
+	return fmt.Sprintf("resolving %s (deployment %s) to %s: %s", e.Host, e.DeploymentId, e.Endpoint, e.Err)
+}
+
+func (e *GeofenceError) Unwrap() error {
+	return e.Err
+}
+
+type geofenceContextKey struct{}
+
+// WithGeofence returns a copy of ctx that makes requests done with it only be sent to endpoints within region, on top
+// of the geofence of their deployment, if any.
+func WithGeofence(ctx context.Context, region s2.Region) context.Context {
+	return context.WithValue(ctx, geofenceContextKey{}, region)
+}
+
+// SetDeploymentGeofence makes requests to services of deploymentId only be sent to endpoints within region, e.g. for
+// data residency. Passing a nil region removes the geofence.
+func (c *Client) SetDeploymentGeofence(deploymentId string, region s2.Region) {
+	if region == nil {
+		c.deploymentGeofences.Delete(deploymentId)
+		return
+	}
+	c.deploymentGeofences.Store(deploymentId, region)
+}
+
+// GeofenceFromCells returns a geofence made of cells, e.g. the covering of a country.
+func GeofenceFromCells(cells ...s2.CellID) s2.Region {
+	union := s2.CellUnion(append([]s2.CellID(nil), cells...))
+	union.Normalize()
+	return &union
+}
+
+// GeofenceFromCap returns a geofence with every point up to radiusMeters away from center.
+func GeofenceFromCap(center s2.LatLng, radiusMeters float64) s2.Region {
+	return s2.CapFromCenterAngle(s2.PointFromLatLng(center), s1.Angle(radiusMeters/earthRadiusMeters))
+}
+
+// LoadGeofenceGeoJSON reads a geofence from the GeoJSON file at path. See ParseGeofenceGeoJSON.
+func LoadGeofenceGeoJSON(path string) (s2.Region, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseGeofenceGeoJSON(data)
+}
+
+// ParseGeofenceGeoJSON returns the geofence made of the polygons in a GeoJSON Polygon, MultiPolygon, Feature or
+// FeatureCollection. Holes are kept out of the geofence.
+func ParseGeofenceGeoJSON(data []byte) (s2.Region, error) {
+	var object geoJSONObject
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidGeoJSON, err)
+	}
+
+	var loops []*s2.Loop
+	if err := object.appendLoops(&loops); err != nil {
+		return nil, err
+	}
+
+	if len(loops) == 0 {
+		return nil, fmt.Errorf("%w: no polygons", ErrInvalidGeoJSON)
+	}
+	return s2.PolygonFromLoops(loops), nil
+}
+
+type geoJSONObject struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSONObject  `json:"geometry"`
+	Features    []geoJSONObject `json:"features"`
+	Geometries  []geoJSONObject `json:"geometries"`
+}
+
+func (o *geoJSONObject) appendLoops(loops *[]*s2.Loop) error {
+	switch o.Type {
+	case "FeatureCollection":
+		for i := range o.Features {
+			if err := o.Features[i].appendLoops(loops); err != nil {
+				return err
+			}
+		}
+	case "GeometryCollection":
+		for i := range o.Geometries {
+			if err := o.Geometries[i].appendLoops(loops); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if o.Geometry == nil {
+			return nil
+		}
+		return o.Geometry.appendLoops(loops)
+	case "Polygon":
+		var rings [][][]float64
+		if err := json.Unmarshal(o.Coordinates, &rings); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidGeoJSON, err)
+		}
+		return appendRings(loops, rings)
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := json.Unmarshal(o.Coordinates, &polygons); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidGeoJSON, err)
+		}
+		for _, rings := range polygons {
+			if err := appendRings(loops, rings); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%w: unsupported type %q", ErrInvalidGeoJSON, o.Type)
+	}
+
+	return nil
+}
+
+// appendRings turns the linear rings of a GeoJSON polygon, whose positions are [lng, lat] and whose last position
+// repeats the first, into loops. Loops are normalized, since s2 works out holes from how loops nest.
+func appendRings(loops *[]*s2.Loop, rings [][][]float64) error {
+	for _, ring := range rings {
+		if len(ring) > 1 && ring[0][0] == ring[len(ring)-1][0] && ring[0][1] == ring[len(ring)-1][1] {
+			ring = ring[:len(ring)-1]
+		}
+		if len(ring) < 3 {
+			return fmt.Errorf("%w: ring with less than 3 positions", ErrInvalidGeoJSON)
+		}
+
+		points := make([]s2.Point, 0, len(ring))
+		for _, position := range ring {
+			if len(position) < 2 {
+				return fmt.Errorf("%w: position without longitude and latitude", ErrInvalidGeoJSON)
+			}
+			points = append(points, s2.PointFromLatLng(s2.LatLngFromDegrees(position[1], position[0])))
+		}
+
+		loop := s2.LoopFromPoints(points)
+		loop.Normalize()
+		*loops = append(*loops, loop)
+	}
+
+	return nil
+}
+
+// StaticEndpointLocations returns an EndpointLocator for endpoints whose locations are known beforehand, keyed by
+// host:port or just by host.
+func StaticEndpointLocations(locations map[string]s2.LatLng) EndpointLocator {
+	return func(_, endpoint string) (s2.LatLng, error) {
+		if location, ok := locations[endpoint]; ok {
+			return location, nil
+		}
+
+		if host, _, err := net.SplitHostPort(endpoint); err == nil {
+			if location, ok := locations[host]; ok {
+				return location, nil
+			}
+		}
+
+		return s2.LatLng{}, fmt.Errorf("%w: %s", ErrEndpointLocationUnknown, endpoint)
+	}
+}
+
+// geofencesFor returns the geofences requests done with ctx to services of deploymentId must respect.
+func (c *Client) geofencesFor(ctx context.Context, deploymentId string) []s2.Region {
+	var geofences []s2.Region
+	if region, ok := ctx.Value(geofenceContextKey{}).(s2.Region); ok && region != nil {
+		geofences = append(geofences, region)
+	}
+	if value, ok := c.deploymentGeofences.Load(deploymentId); ok {
+		geofences = append(geofences, value.(s2.Region))
+	}
+	return geofences
+}
+
+// checkGeofences returns a GeofenceError if endpoint is not within every geofence or its location is unknown.
+func (c *Client) checkGeofences(geofences []s2.Region, deploymentId, hostPort, endpoint string) error {
+	geofenceErr := &GeofenceError{DeploymentId: deploymentId, Host: hostPort, Endpoint: endpoint}
+
+	if c.EndpointLocator == nil {
+		geofenceErr.Err = fmt.Errorf("%w: client has no EndpointLocator", ErrEndpointLocationUnknown)
+		return geofenceErr
+	}
+
+	location, err := c.EndpointLocator(deploymentId, endpoint)
+	if err != nil {
+		if !errors.Is(err, ErrEndpointLocationUnknown) {
+			err = fmt.Errorf("%w: %w", ErrEndpointLocationUnknown, err)
+		}
+		geofenceErr.Err = err
+		return geofenceErr
+	}
+
+	point := s2.PointFromLatLng(location)
+	for _, geofence := range geofences {
+		if !geofence.ContainsPoint(point) {
+			geofenceErr.Err = ErrOutsideGeofence
+			return geofenceErr
+		}
+	}
+
+	return nil
+}
+
+// enforceGeofences checks the endpoint a service was resolved to against its geofences, resolving it again from
+// within the first geofence if the client's GeofencePolicy allows it. It returns the endpoint the request may be sent
+// to, which differs from endpoint if it was resolved again.
+func (c *Client) enforceGeofences(ctx context.Context, geofences []s2.Region, deploymentId, hostPort,
+	endpoint string, opts *resolveOptions) (string, error) {
+	err := c.checkGeofences(geofences, deploymentId, hostPort, endpoint)
+	if err == nil {
+		return endpoint, nil
+	}
+
+	reqId, _ := RequestIDFromContext(ctx)
+	if c.GeofencePolicy != GeofenceReresolve || !errors.Is(err, ErrOutsideGeofence) {
+		c.metrics().GeofenceViolation(deploymentId, GeofenceRejected)
+		c.logger().Warn("rejected endpoint", LogFieldReqId, reqId, LogFieldDeployment, deploymentId,
+			LogFieldHost, hostPort, LogFieldEndpoint, endpoint, LogFieldError, err)
+		return "", err
+	}
+
+	c.metrics().GeofenceViolation(deploymentId, GeofenceReresolved)
+	c.logger().Info("endpoint outside geofence, resolving again", LogFieldReqId, reqId,
+		LogFieldDeployment, deploymentId, LogFieldHost, hostPort, LogFieldEndpoint, endpoint)
+
+	geofenceOpts := *opts
+	geofenceOpts.noCache = true
+	geofenceOpts.location = s2.CellFromPoint(geofences[0].CapBound().Center()).ID()
+
+	reresolved, found, resolveErr := c.resolveServiceInArchimedes(ctx, hostPort, &geofenceOpts)
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+
+	if found {
+		if err = c.checkGeofences(geofences, deploymentId, hostPort, reresolved); err == nil {
+			return reresolved, nil
+		}
+	}
+
+	c.metrics().GeofenceViolation(deploymentId, GeofenceRejected)
+	c.logger().Warn("rejected endpoint", LogFieldReqId, reqId, LogFieldDeployment, deploymentId,
+		LogFieldHost, hostPort, LogFieldEndpoint, reresolved, LogFieldError, err)
+	return "", err
+}
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	squareRing        = `[[-10, 38], [-8, 38], [-8, 40], [-10, 40], [-10, 38]]`
+	clockwiseRing     = `[[-10, 38], [-10, 40], [-8, 40], [-8, 38], [-10, 38]]`
+	holeRing          = `[[-9.2, 38.8], [-8.8, 38.8], [-8.8, 39.2], [-9.2, 39.2]]`
+	squarePolygon     = `{"type": "Polygon", "coordinates": [` + squareRing + `]}`
+	otherSquareRing   = `[[10, 38], [12, 38], [12, 40], [10, 40], [10, 38]]`
+	otherSquareInside = "39,11"
+)
+
+func TestParseGeofenceGeoJSON(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            string
+		inside, outside []string
+		wantErr         bool
+	}{
+		{name: "polygon", data: squarePolygon, inside: []string{"38.5,-9.5", "39,-9"}, outside: []string{"41,-9"}},
+		{
+			name:   "clockwise polygon",
+			data:   `{"type": "Polygon", "coordinates": [` + clockwiseRing + `]}`,
+			inside: []string{"39,-9"}, outside: []string{"41,-9", otherSquareInside},
+		},
+		{
+			name:   "polygon with hole",
+			data:   `{"type": "Polygon", "coordinates": [` + squareRing + `, ` + holeRing + `]}`,
+			inside: []string{"38.5,-9.5"}, outside: []string{"39,-9", "41,-9"},
+		},
+		{
+			name:   "multipolygon",
+			data:   `{"type": "MultiPolygon", "coordinates": [[` + squareRing + `], [` + otherSquareRing + `]]}`,
+			inside: []string{"39,-9", otherSquareInside}, outside: []string{"39,0"},
+		},
+		{
+			name:   "feature",
+			data:   `{"type": "Feature", "properties": {}, "geometry": ` + squarePolygon + `}`,
+			inside: []string{"39,-9"}, outside: []string{"41,-9"},
+		},
+		{
+			name: "feature collection",
+			data: `{"type": "FeatureCollection", "features": [{"type": "Feature", "geometry": null}, ` +
+				`{"type": "Feature", "geometry": ` + squarePolygon + `}]}`,
+			inside: []string{"39,-9"}, outside: []string{"41,-9"},
+		},
+		{
+			name:   "geometry collection",
+			data:   `{"type": "GeometryCollection", "geometries": [` + squarePolygon + `]}`,
+			inside: []string{"39,-9"}, outside: []string{"41,-9"},
+		},
+		{name: "invalid JSON", data: `{"type": `, wantErr: true},
+		{name: "unsupported type", data: `{"type": "Point", "coordinates": [-9, 39]}`, wantErr: true},
+		{name: "no polygons", data: `{"type": "FeatureCollection", "features": []}`, wantErr: true},
+		{
+			name:    "short ring",
+			data:    `{"type": "Polygon", "coordinates": [[[-10, 38], [-8, 38], [-10, 38]]]}`,
+			wantErr: true,
+		},
+		{
+			name:    "short position",
+			data:    `{"type": "Polygon", "coordinates": [[[-10], [-8, 38], [-8, 40]]]}`,
+			wantErr: true,
+		},
+		{name: "invalid coordinates", data: `{"type": "Polygon", "coordinates": "square"}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			region, err := ParseGeofenceGeoJSON([]byte(test.data))
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidGeoJSON) {
+					t.Errorf("got %v, want ErrInvalidGeoJSON", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, location := range test.inside {
+				if !geofenceContains(t, region, location) {
+					t.Errorf("%s is outside the geofence", location)
+				}
+			}
+			for _, location := range test.outside {
+				if geofenceContains(t, region, location) {
+					t.Errorf("%s is inside the geofence", location)
+				}
+			}
+		})
+	}
+}
+
+func geofenceContains(t *testing.T, region s2.Region, location string) bool {
+	t.Helper()
+
+	cell, err := ParseLocation(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return region.ContainsPoint(cell.Point())
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"net/url"
+)
+
+// HostPolicy decides the Host header sent once a request URL is rewritten to the endpoint archimedes resolved.
+type HostPolicy int
+
+const (
+	// HostKeepLogical sends the logical service host as Host header, so instances relying on name-based virtual
+	// hosting keep working.
+	HostKeepLogical HostPolicy = iota
+	// HostUseResolved sends the resolved endpoint as Host header.
+	HostUseResolved
+	// HostServiceHeader sends the resolved endpoint as Host header and the logical service host in the client's
+	// ServiceHeader.
+	HostServiceHeader
+)
+
+// DefaultServiceHeader is the header the logical service host is sent in when using HostServiceHeader and the client
+// has no ServiceHeader.
+const DefaultServiceHeader = "X-Archimedes-Service"
+
+// logicalHost returns the service host the request was addressed to, before being rewritten.
+func logicalHost(req *Request) string {
+	if req.Host != "" {
+		return req.Host
+	}
+	return req.URL.Host
+}
+
+func (c *Client) applyHostPolicy(req *Request, logical, resolvedHostPort string) {
+	if logical == resolvedHostPort {
+		return
+	}
+
+	switch c.HostPolicy {
+	case HostKeepLogical:
+		req.Host = logical
+	case HostUseResolved:
+		req.Host = resolvedHostPort
+	case HostServiceHeader:
+		serviceHeader := c.ServiceHeader
+		if serviceHeader == "" {
+			serviceHeader = DefaultServiceHeader
+		}
+
+		if req.Header == nil {
+			req.Header = Header{}
+		}
+
+		req.Host = resolvedHostPort
+		req.Header.Set(serviceHeader, logical)
+	}
+}
+
+// mapLocationToLogical rewrites the Location header of a redirect response that points back at an endpoint resolved
+// during the Do call so that it names the logical service host instead.
+func (c *Client) mapLocationToLogical(resp *Response, hops *requestHops) {
+	if resp == nil {
+		return
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return
+	}
+
+	locationUrl, err := url.Parse(location)
+	if err != nil {
+		return
+	}
+
+	logical, ok := hops.logicalFor(locationUrl.Host)
+	if !ok || logical == locationUrl.Host {
+		return
+	}
+
+	locationUrl.Host = logical
+	resp.Header.Set("Location", locationUrl.String())
+	reqId, _ := RequestIDFromContext(resp.Request.Context())
+	c.logger().Debug("mapped redirect location back to logical host", LogFieldReqId, reqId, "location", location,
+		LogFieldHost, logical)
+}
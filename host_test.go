@@ -0,0 +1,126 @@
+package http
+
+import (
+	originalHttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newVirtualHostClient returns a client that resolves svc to a server that, like one using name-based virtual
+// hosting, only serves requests whose Host is svc. The server answers with the Host and service header it got.
+func newVirtualHostClient(t *testing.T) (*Client, string) {
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("X-Got-Host", r.Host)
+		w.Header().Set("X-Got-Service", r.Header.Get(DefaultServiceHeader)+r.Header.Get("X-Service"))
+		if r.Host != "svc" {
+			w.WriteHeader(StatusMisdirectedRequest)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	endpoint := server.Listener.Addr().String()
+	return newFakeArchimedesClient(&fakeArchimedes{endpoints: map[string]string{"svc": endpoint}}), endpoint
+}
+
+func TestHostPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        HostPolicy
+		serviceHeader string
+		wantStatus    int
+		wantHost      string
+		wantService   string
+	}{
+		{name: "keep logical", policy: HostKeepLogical, wantStatus: StatusOK, wantHost: "svc"},
+		{
+			name:       "use resolved",
+			policy:     HostUseResolved,
+			wantStatus: StatusMisdirectedRequest,
+			wantHost:   "endpoint",
+		},
+		{
+			name:        "service header",
+			policy:      HostServiceHeader,
+			wantStatus:  StatusMisdirectedRequest,
+			wantHost:    "endpoint",
+			wantService: "svc",
+		},
+		{
+			name:          "custom service header",
+			policy:        HostServiceHeader,
+			serviceHeader: "X-Service",
+			wantStatus:    StatusMisdirectedRequest,
+			wantHost:      "endpoint",
+			wantService:   "svc",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, endpoint := newVirtualHostClient(t)
+			c.HostPolicy = test.policy
+			c.ServiceHeader = test.serviceHeader
+
+			resp, err := c.Get("http://svc/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			wantHost := test.wantHost
+			if wantHost == "endpoint" {
+				wantHost = endpoint
+			}
+
+			if resp.StatusCode != test.wantStatus {
+				t.Errorf("got status %d, want %d", resp.StatusCode, test.wantStatus)
+			}
+			if got := resp.Header.Get("X-Got-Host"); got != wantHost {
+				t.Errorf("server got Host %q, want %q", got, wantHost)
+			}
+			if got := resp.Header.Get("X-Got-Service"); got != test.wantService {
+				t.Errorf("server got service %q, want %q", got, test.wantService)
+			}
+		})
+	}
+}
+
+func TestMapLocationToLogical(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		want     string
+	}{
+		{name: "endpoint", location: "http://endpoint/next?a=b", want: "http://svc/next?a=b"},
+		{name: "relative", location: "/next", want: "/next"},
+		{name: "other host", location: "http://other-svc/next", want: "http://other-svc/next"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var endpoint string
+			server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {
+				w.Header().Set("Location", strings.Replace(test.location, "endpoint", endpoint, 1))
+				w.WriteHeader(StatusFound)
+			}))
+			defer server.Close()
+			endpoint = server.Listener.Addr().String()
+
+			c := newFakeArchimedesClient(&fakeArchimedes{endpoints: map[string]string{"svc": endpoint}})
+			c.CheckRedirect = func(*Request, []*Request) error {
+				return ErrUseLastResponse
+			}
+
+			resp, err := c.Get("http://svc/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			if got := resp.Header.Get("Location"); got != test.want {
+				t.Errorf("got Location %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package http
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+const defaultProtocol = "tcp"
+
+var (
+	// DefaultSchemePorts are the ports used for URLs without an explicit port, by scheme. They can be overridden per
+	// client with SchemePorts.
+	DefaultSchemePorts = map[string]string{
+		"http":  "80",
+		"https": "443",
+		"ws":    "80",
+		"wss":   "443",
+	}
+
+	// DefaultSchemeProtocols are the transport protocols archimedes is asked to resolve ports for, by scheme. Schemes
+	// that are not here or in the client's SchemeProtocols use tcp.
+	DefaultSchemeProtocols = map[string]string{
+		"http":  "tcp",
+		"https": "tcp",
+		"ws":    "tcp",
+		"wss":   "tcp",
+	}
+)
+
+// hostPortForRequest returns the host:port the request is addressed to, filling in the default port for the request
+// scheme when the request does not have one.
+func (c *Client) hostPortForRequest(req *Request) (string, error) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	return c.withDefaultPort(req.URL.Scheme, host)
+}
+
+func (c *Client) withDefaultPort(scheme, host string) (string, error) {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host, nil
+	}
+
+	port, ok := c.SchemePorts[scheme]
+	if !ok {
+		port, ok = DefaultSchemePorts[scheme]
+	}
+	if !ok {
+		return "", fmt.Errorf("%s has no port and there is no default port for scheme %q", host, scheme)
+	}
+
+	// IPv6 literals without a port still come in brackets
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return net.JoinHostPort(host, port), nil
+}
+
+// serviceHostPort returns the host:port the client keys a service by, for APIs that take a service from the caller.
+// The service can be given as a host:port, as a URL, whose scheme gives the default port, or as a bare host, which is
+// taken to use the default http port. Services that can not be normalized are returned as given.
+func (c *Client) serviceHostPort(service string) string {
+	scheme, host := "http", service
+	if strings.Contains(service, "://") {
+		serviceUrl, err := url.Parse(service)
+		if err != nil {
+			return service
+		}
+		scheme, host = serviceUrl.Scheme, serviceUrl.Host
+	}
+
+	hostPort, err := c.withDefaultPort(scheme, host)
+	if err != nil {
+		return service
+	}
+	return hostPort
+}
+
+func (c *Client) protocolForScheme(scheme string) string {
+	if protocol, ok := c.SchemeProtocols[scheme]; ok {
+		return protocol
+	}
+
+	if protocol, ok := DefaultSchemeProtocols[scheme]; ok {
+		return protocol
+	}
+
+	return defaultProtocol
+}
+
+// isLiteralHostPort tells whether hostPort already is an IP address, in which case it does not need to be resolved.
+func isLiteralHostPort(hostPort string) bool {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return false
+	}
+
+	return net.ParseIP(host) != nil
+}
+
+func newPort(rawPort, protocol string) (nat.Port, error) {
+	if protocol == "" {
+		protocol = defaultProtocol
+	}
+
+	return nat.NewPort(protocol, rawPort)
+}
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"testing"
+)
+
+func TestWithDefaultPort(t *testing.T) {
+	c := &Client{SchemePorts: map[string]string{"coap": "5683", "http": "8080"}}
+
+	tests := []struct {
+		scheme  string
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{scheme: "https", host: "svc", want: "svc:443"},
+		{scheme: "ws", host: "svc", want: "svc:80"},
+		{scheme: "wss", host: "svc", want: "svc:443"},
+		{scheme: "http", host: "svc", want: "svc:8080"},
+		{scheme: "coap", host: "svc", want: "svc:5683"},
+		{scheme: "https", host: "svc:8443", want: "svc:8443"},
+		{scheme: "unknown", host: "svc:1234", want: "svc:1234"},
+		{scheme: "https", host: "10.0.0.1", want: "10.0.0.1:443"},
+		{scheme: "https", host: "[::1]", want: "[::1]:443"},
+		{scheme: "https", host: "[::1]:8443", want: "[::1]:8443"},
+		{scheme: "unknown", host: "svc", wantErr: true},
+	}
+
+	for _, test := range tests {
+		got, err := c.withDefaultPort(test.scheme, test.host)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s %s: got %q, want an error", test.scheme, test.host, got)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s %s: %v", test.scheme, test.host, err)
+		} else if got != test.want {
+			t.Errorf("%s %s: got %q, want %q", test.scheme, test.host, got, test.want)
+		}
+	}
+}
+
+func TestServiceHostPort(t *testing.T) {
+	c := &Client{}
+
+	tests := map[string]string{
+		"svc":               "svc:80",
+		"svc:8080":          "svc:8080",
+		"http://svc/":       "svc:80",
+		"https://svc":       "svc:443",
+		"https://svc:8443/": "svc:8443",
+		"wss://svc/stream":  "svc:443",
+		"[::1]":             "[::1]:80",
+		"unknown://svc":     "unknown://svc",
+	}
+
+	for service, want := range tests {
+		if got := c.serviceHostPort(service); got != want {
+			t.Errorf("%s: got %q, want %q", service, got, want)
+		}
+	}
+}
+
+func TestIsLiteralHostPort(t *testing.T) {
+	tests := map[string]bool{
+		"10.0.0.1:80": true,
+		"[::1]:80":    true,
+		"svc:80":      false,
+		"10.0.0.1":    false,
+	}
+
+	for hostPort, want := range tests {
+		if got := isLiteralHostPort(hostPort); got != want {
+			t.Errorf("%s: got %t, want %t", hostPort, got, want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	originalHttp "net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// InvalidationsPath is the path in archimedes that is long-polled for invalidations of cached deployments.
+	InvalidationsPath = "/archimedes/invalidations"
+
+	invalidationPollTimeout  = 30 * time.Second
+	invalidationRetryTimeout = 5 * time.Second
+)
+
+// Invalidation is sent by archimedes when the resolutions for a deployment are no longer valid. If Host is set only
+// the resolution for that host is affected, otherwise every host of the deployment is. If Resolved is set the
+// affected cache entries are updated to it instead of being evicted.
+type Invalidation struct {
+	DeploymentId string `json:"deployment_id"`
+	Host         string `json:"host,omitempty"`
+	Resolved     string `json:"resolved,omitempty"`
+}
+
+// SubscribeToInvalidations makes the client long-poll its archimedes server for invalidations of the deployments it
+// has cached, evicting or updating the matching cache entries as soon as they arrive instead of waiting for them to
+// expire. The subscription lasts until ctx is done.
+//
+// Archimedes is expected to answer GET requests to InvalidationsPath, with the deployments as a comma separated list
+// in the deployments query parameter, with a JSON list of Invalidation once it has any or with StatusNoContent after
+// the timeout query parameter (in seconds) expires.
+func (c *Client) SubscribeToInvalidations(ctx context.Context) {
+	go c.pollInvalidations(ctx)
+}
+
+func (c *Client) pollInvalidations(ctx context.Context) {
+	httpClient := &originalHttp.Client{Timeout: invalidationPollTimeout + invalidationRetryTimeout}
+	c.logger().Info("subscribed to archimedes invalidations")
+
+	for {
+		deploymentIds := c.cachedDeployments()
+
+		wait := time.Duration(0)
+		if len(deploymentIds) == 0 {
+			wait = refreshCacheTimeout
+		} else {
+			invalidations, err := c.fetchInvalidations(ctx, httpClient, deploymentIds)
+			if err != nil {
+				c.logger().Warn("error polling archimedes for invalidations", LogFieldError, err)
+				wait = invalidationRetryTimeout
+			}
+
+			for _, invalidation := range invalidations {
+				c.applyInvalidation(invalidation)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			c.logger().Info("unsubscribed from archimedes invalidations")
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) fetchInvalidations(ctx context.Context, httpClient *originalHttp.Client,
+	deploymentIds []string) ([]Invalidation, error) {
+	c.RLock()
+	archimedesAddr := c.archimedesAddr
+	c.RUnlock()
+
+	query := url.Values{}
+	query.Set("deployments", strings.Join(deploymentIds, ","))
+	query.Set("timeout", strconv.Itoa(int(invalidationPollTimeout.Seconds())))
+	pollUrl := url.URL{
+		Scheme:   "http",
+		Host:     archimedesAddr,
+		Path:     InvalidationsPath,
+		RawQuery: query.Encode(),
+	}
+
+	req, err := originalHttp.NewRequestWithContext(ctx, originalHttp.MethodGet, pollUrl.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case StatusNoContent:
+		return nil, nil
+	case StatusOK:
+	default:
+		return nil, fmt.Errorf("got status %d from %s", resp.StatusCode, archimedesAddr)
+	}
+
+	var invalidations []Invalidation
+	if err = json.NewDecoder(resp.Body).Decode(&invalidations); err != nil {
+		return nil, err
+	}
+
+	return invalidations, nil
+}
+
+func (c *Client) cachedDeployments() []string {
+	seen := map[string]struct{}{}
+	var deploymentIds []string
+	c.cache.Range(func(key, value interface{}) bool {
+		entry := value.(addressCacheValue)
+		if _, ok := seen[entry.deploymentId]; !ok {
+			seen[entry.deploymentId] = struct{}{}
+			deploymentIds = append(deploymentIds, entry.deploymentId)
+		}
+		return true
+	})
+
+	return deploymentIds
+}
+
+func (c *Client) applyInvalidation(invalidation Invalidation) {
+	c.cache.Range(func(key, value interface{}) bool {
+		cacheKey := key.(addressCacheKey)
+		hostPort := cacheKey.hostPort
+		entry := value.(addressCacheValue)
+		if entry.deploymentId != invalidation.DeploymentId ||
+			(invalidation.Host != "" && invalidation.Host != hostPort) {
+			return true
+		}
+
+		oldResolved := entry.getResolved()
+		if invalidation.Resolved != "" {
+			c.logger().Debug("archimedes updated endpoint", LogFieldDeployment, entry.deploymentId,
+				LogFieldHost, hostPort, "old_endpoint", oldResolved, LogFieldEndpoint, invalidation.Resolved)
+			newEntry := newCacheEntry(invalidation.Resolved, entry.deploymentId, entry.protocol)
+			newEntry.clientLocation = entry.clientLocation
+			c.cache.Store(cacheKey, newEntry)
+			go waitAndSetValueAsStale(newEntry)
+			if cacheKey == c.ownCacheKey(hostPort) {
+				c.notifyResolutionChange(hostPort, oldResolved, invalidation.Resolved, ReasonInvalidated)
+			}
+			return true
+		}
+
+		c.logger().Debug("archimedes invalidated endpoint", LogFieldDeployment, entry.deploymentId,
+			LogFieldHost, hostPort, "old_endpoint", oldResolved)
+		c.cache.Delete(cacheKey)
+		if c.isWatched(hostPort) && cacheKey == c.ownCacheKey(hostPort) {
+			go c.reresolveWatched(hostPort, oldResolved, ReasonInvalidated)
+		}
+		return true
+	})
+}
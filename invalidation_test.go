@@ -0,0 +1,189 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	originalHttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newInvalidationTestClient(archimedesAddr string) *Client {
+	c := &Client{archimedesAddr: archimedesAddr}
+	c.cache.Store(c.ownCacheKey("a:80"), newCacheEntry("10.0.0.1:80", "d1", "tcp"))
+	c.cache.Store(c.ownCacheKey("b:80"), newCacheEntry("10.0.0.2:80", "d1", "tcp"))
+	c.cache.Store(c.ownCacheKey("c:80"), newCacheEntry("10.0.0.3:80", "d2", "tcp"))
+	return c
+}
+
+func cachedEndpoint(c *Client, hostPort string) (string, bool) {
+	value, ok := c.cache.Load(c.ownCacheKey(hostPort))
+	if !ok {
+		return "", false
+	}
+	return value.(addressCacheValue).getResolved(), true
+}
+
+func TestApplyInvalidation(t *testing.T) {
+	tests := []struct {
+		name         string
+		invalidation Invalidation
+		want         map[string]string
+	}{
+		{
+			name:         "evict deployment",
+			invalidation: Invalidation{DeploymentId: "d1"},
+			want:         map[string]string{"c:80": "10.0.0.3:80"},
+		},
+		{
+			name:         "evict host",
+			invalidation: Invalidation{DeploymentId: "d1", Host: "a:80"},
+			want:         map[string]string{"b:80": "10.0.0.2:80", "c:80": "10.0.0.3:80"},
+		},
+		{
+			name:         "update deployment",
+			invalidation: Invalidation{DeploymentId: "d1", Resolved: "10.0.0.9:80"},
+			want:         map[string]string{"a:80": "10.0.0.9:80", "b:80": "10.0.0.9:80", "c:80": "10.0.0.3:80"},
+		},
+		{
+			name:         "update host",
+			invalidation: Invalidation{DeploymentId: "d1", Host: "b:80", Resolved: "10.0.0.9:80"},
+			want:         map[string]string{"a:80": "10.0.0.1:80", "b:80": "10.0.0.9:80", "c:80": "10.0.0.3:80"},
+		},
+		{
+			name:         "other deployment",
+			invalidation: Invalidation{DeploymentId: "d3"},
+			want:         map[string]string{"a:80": "10.0.0.1:80", "b:80": "10.0.0.2:80", "c:80": "10.0.0.3:80"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newInvalidationTestClient("")
+			c.applyInvalidation(test.invalidation)
+
+			for _, hostPort := range []string{"a:80", "b:80", "c:80"} {
+				got, ok := cachedEndpoint(c, hostPort)
+				want, wantOk := test.want[hostPort]
+				if ok != wantOk || got != want {
+					t.Errorf("%s: got %q (cached %t), want %q (cached %t)", hostPort, got, ok, want, wantOk)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyInvalidationNotifiesWatchers(t *testing.T) {
+	c := newInvalidationTestClient("")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := c.Watch(ctx, "a:80")
+
+	c.applyInvalidation(Invalidation{DeploymentId: "d1", Host: "a:80", Resolved: "10.0.0.9:80"})
+
+	select {
+	case change := <-changes:
+		want := ResolutionChange{Host: "a:80", Old: "10.0.0.1:80", New: "10.0.0.9:80", Reason: ReasonInvalidated}
+		if change != want {
+			t.Errorf("got %+v, want %+v", change, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watcher was not notified")
+	}
+}
+
+func TestFetchInvalidationsNoContent(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {
+		query = r.URL.Query()
+		w.WriteHeader(StatusNoContent)
+	}))
+	defer server.Close()
+
+	c := newInvalidationTestClient(server.Listener.Addr().String())
+	invalidations, err := c.fetchInvalidations(context.Background(), server.Client(), []string{"d1", "d2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invalidations) != 0 {
+		t.Errorf("got %v, want no invalidations", invalidations)
+	}
+	if got := query.Get("deployments"); got != "d1,d2" {
+		t.Errorf("got deployments %q, want %q", got, "d1,d2")
+	}
+}
+
+func TestFetchInvalidationsBadStatus(t *testing.T) {
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := newInvalidationTestClient(server.Listener.Addr().String())
+	if _, err := c.fetchInvalidations(context.Background(), server.Client(), []string{"d1"}); err == nil {
+		t.Error("got no error for status 500")
+	}
+}
+
+// TestPollInvalidations runs the subscription against a stand-in archimedes that sends one batch of invalidations,
+// answers the next poll with no content and then holds every other poll open, like a long-poll with nothing to say,
+// until the subscription is canceled.
+func TestPollInvalidations(t *testing.T) {
+	var polls int32
+	noContent := make(chan struct{})
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {
+		if !strings.Contains(r.URL.Query().Get("deployments"), "d1") {
+			t.Errorf("poll without d1: %s", r.URL.RawQuery)
+		}
+
+		switch atomic.AddInt32(&polls, 1) {
+		case 1:
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode([]Invalidation{
+				{DeploymentId: "d1", Host: "a:80"},
+				{DeploymentId: "d1", Host: "b:80", Resolved: "10.0.0.9:80"},
+			})
+		case 2:
+			w.WriteHeader(StatusNoContent)
+			close(noContent)
+		default:
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	c := newInvalidationTestClient(server.Listener.Addr().String())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.pollInvalidations(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-noContent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("archimedes was not polled again")
+	}
+
+	if got, ok := cachedEndpoint(c, "a:80"); ok {
+		t.Errorf("a:80 still cached as %q, want it evicted", got)
+	}
+	if got, _ := cachedEndpoint(c, "b:80"); got != "10.0.0.9:80" {
+		t.Errorf("b:80 cached as %q, want it updated to %q", got, "10.0.0.9:80")
+	}
+	if got, _ := cachedEndpoint(c, "c:80"); got != "10.0.0.3:80" {
+		t.Errorf("c:80 cached as %q, want it untouched", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("subscription did not stop when its context was canceled")
+	}
+}
@@ -0,0 +1,222 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logicalJar wraps the client's cookie jar for a single Do call, so that cookies are stored and looked up under the
+// logical host of each request instead of the endpoint it was rewritten to. Sessions then survive archimedes
+// switching the service to a different instance.
+type logicalJar struct {
+	base CookieJar
+	hops *requestHops
+}
+
+func (j *logicalJar) SetCookies(u *url.URL, cookies []*Cookie) {
+	j.base.SetCookies(j.hops.logicalURL(u), cookies)
+}
+
+func (j *logicalJar) Cookies(u *url.URL) []*Cookie {
+	return j.base.Cookies(j.hops.logicalURL(u))
+}
+
+type (
+	persistedCookiesMapKey   = string
+	persistedCookiesMapValue = map[string]*Cookie
+)
+
+// PersistentJar is a CookieJar that saves every cookie it is given to a file, so that login sessions survive both
+// instance migrations and client restarts. When used as the client's Jar, cookies are kept under the logical host of
+// each service.
+type PersistentJar struct {
+	// Logger receives the errors persisting cookies. If nil, they are written to the standard logrus logger.
+	Logger Logger
+
+	path    string
+	jar     *cookiejar.Jar
+	cookies map[persistedCookiesMapKey]persistedCookiesMapValue
+	sync.Mutex
+}
+
+// NewPersistentJar returns a PersistentJar that saves cookies to the file at path, loading the ones that were
+// already saved there and have not expired yet.
+func NewPersistentJar(path string) (*PersistentJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	persistentJar := &PersistentJar{
+		path:    path,
+		jar:     jar,
+		cookies: map[persistedCookiesMapKey]persistedCookiesMapValue{},
+		Mutex:   sync.Mutex{},
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return persistentJar, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &persistentJar.cookies); err != nil {
+		return nil, err
+	}
+
+	persisted := persistentJar.cookies
+	persistentJar.cookies = map[persistedCookiesMapKey]persistedCookiesMapValue{}
+
+	now := time.Now()
+	for rawUrl, cookies := range persisted {
+		u, err := url.Parse(rawUrl)
+		if err != nil {
+			defaultLogger.Warn("dropping persisted cookies for invalid url", "url", rawUrl, LogFieldError, err)
+			continue
+		}
+
+		// files written before cookies were kept per scheme and host have them under the full request url
+		toSet := make([]*Cookie, 0, len(cookies))
+		for _, cookie := range cookies {
+			if cookie.MaxAge < 0 || (!cookie.Expires.IsZero() && cookie.Expires.Before(now)) {
+				continue
+			}
+			cookie = withCookiePath(u, cookie)
+			persistentJar.persist(u, cookie)
+			toSet = append(toSet, cookie)
+		}
+		jar.SetCookies(u, toSet)
+	}
+
+	defaultLogger.Debug("loaded persisted cookies", "path", path)
+
+	return persistentJar, nil
+}
+
+// SetCookies stores the cookies in the jar and saves them. Since a Max-Age is relative to when the cookie was received,
+// it is saved as an absolute expiry instead, and cookies being deleted are removed from the file.
+//
+// Cookies are saved per scheme and host, under their name, domain and path, so that a cookie is replaced or deleted
+// whatever the path of the request that does it, like in the jar itself.
+func (j *PersistentJar) SetCookies(u *url.URL, cookies []*Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	now := time.Now()
+
+	j.Lock()
+	defer j.Unlock()
+
+	for _, cookie := range cookies {
+		cookie = withCookiePath(u, cookie)
+		if cookie.MaxAge < 0 || (!cookie.Expires.IsZero() && cookie.Expires.Before(now)) {
+			j.forget(u, cookie)
+			continue
+		}
+
+		if cookie.MaxAge > 0 {
+			cookie.Expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+			cookie.MaxAge = 0
+		}
+		j.persist(u, cookie)
+	}
+
+	if err := j.save(); err != nil {
+		j.logger().Error("error persisting cookies", "path", j.path, LogFieldError, err)
+	}
+}
+
+// persistKey returns the key the cookies of u are saved under.
+func persistKey(u *url.URL) persistedCookiesMapKey {
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host}).String()
+}
+
+func cookieKey(cookie *Cookie) string {
+	return cookie.Name + ";" + strings.ToLower(cookie.Domain) + ";" + cookie.Path
+}
+
+// persist adds cookie, whose path must be set, to the ones saved for u. It must be called with the jar locked.
+func (j *PersistentJar) persist(u *url.URL, cookie *Cookie) {
+	key := persistKey(u)
+	if j.cookies[key] == nil {
+		j.cookies[key] = persistedCookiesMapValue{}
+	}
+	j.cookies[key][cookieKey(cookie)] = cookie
+}
+
+// forget removes cookie, whose path must be set, from the ones saved for u. It must be called with the jar locked.
+func (j *PersistentJar) forget(u *url.URL, cookie *Cookie) {
+	key := persistKey(u)
+	delete(j.cookies[key], cookieKey(cookie))
+	if len(j.cookies[key]) == 0 {
+		delete(j.cookies, key)
+	}
+}
+
+// withCookiePath returns a copy of cookie with its path set to the default path for u when it has none, as the jar
+// does, so that it still applies to the same paths once saved without the path of u.
+func withCookiePath(u *url.URL, cookie *Cookie) *Cookie {
+	withPath := *cookie
+	if withPath.Path == "" || withPath.Path[0] != '/' {
+		withPath.Path = defaultCookiePath(u.Path)
+	}
+	return &withPath
+}
+
+// defaultCookiePath returns the default path of the cookies set by a request to path, as in RFC 6265, section 5.1.4.
+func defaultCookiePath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+func (j *PersistentJar) Cookies(u *url.URL) []*Cookie {
+	return j.jar.Cookies(u)
+}
+
+func (j *PersistentJar) logger() Logger {
+	if j.Logger == nil {
+		return defaultLogger
+	}
+	return j.Logger
+}
+
+// save writes the cookies to a temporary file that then replaces the jar file, so a crash never leaves it half
+// written. It must be called with the jar locked.
+func (j *PersistentJar) save() error {
+	data, err := json.Marshal(j.cookies)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmpFile.Write(data); err != nil {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if err = tmpFile.Close(); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), j.path)
+}
@@ -0,0 +1,155 @@
+package http
+
+import (
+	"net/http/cookiejar"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func cookieNames(cookies []*Cookie) string {
+	names := make([]string, 0, len(cookies))
+	for _, cookie := range cookies {
+		names = append(names, cookie.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestPersistentJar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	u, _ := url.Parse("http://svc/")
+
+	jar, err := NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jar.SetCookies(u, []*Cookie{
+		{Name: "short", Value: "1", MaxAge: 1},
+		{Name: "long", Value: "1", MaxAge: 3600},
+		{Name: "session", Value: "1"},
+		{Name: "deleted", Value: "1"},
+		{Name: "expired", Value: "1", Expires: time.Now().Add(-time.Hour)},
+	})
+	jar.SetCookies(u, []*Cookie{{Name: "deleted", MaxAge: -1}})
+
+	if got, want := cookieNames(jar.Cookies(u)), "long,session,short"; got != want {
+		t.Fatalf("got cookies %s, want %s", got, want)
+	}
+
+	for _, cookie := range jar.cookies[persistKey(u)] {
+		if cookie.MaxAge != 0 {
+			t.Errorf("%s persisted with Max-Age %d, want an absolute expiry", cookie.Name, cookie.MaxAge)
+		}
+	}
+
+	reloaded, err := NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cookieNames(reloaded.Cookies(u)), "long,session,short"; got != want {
+		t.Errorf("got reloaded cookies %s, want %s", got, want)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	reloaded, err = NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := cookieNames(reloaded.Cookies(u)), "long,session"; got != want {
+		t.Errorf("got cookies %s reloaded after short expired, want %s", got, want)
+	}
+}
+
+func TestPersistentJarDeleteFromAnotherPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{name: "default path"},
+		{name: "explicit path", path: "/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cookies.json")
+			login, _ := url.Parse("http://svc/login")
+			logout, _ := url.Parse("http://svc/logout")
+
+			jar, err := NewPersistentJar(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			jar.SetCookies(login, []*Cookie{{Name: "session", Value: "1", Path: test.path}})
+			jar.SetCookies(logout, []*Cookie{{Name: "session", MaxAge: -1, Path: test.path}})
+
+			if got := cookieNames(jar.Cookies(login)); got != "" {
+				t.Fatalf("got cookies %q after logging out, want none", got)
+			}
+
+			reloaded, err := NewPersistentJar(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cookieNames(reloaded.Cookies(login)); got != "" {
+				t.Errorf("got cookies %q reloaded after logging out, want none", got)
+			}
+		})
+	}
+}
+
+func TestPersistentJarPaths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.json")
+	u, _ := url.Parse("http://svc/app/login")
+
+	jar, err := NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar.SetCookies(u, []*Cookie{{Name: "app", Value: "1"}, {Name: "root", Value: "1", Path: "/"}})
+
+	reloaded, err := NewPersistentJar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for rawUrl, want := range map[string]string{"http://svc/app/home": "app,root", "http://svc/other": "root"} {
+		other, _ := url.Parse(rawUrl)
+		if got := cookieNames(reloaded.Cookies(other)); got != want {
+			t.Errorf("got reloaded cookies %s for %s, want %s", got, rawUrl, want)
+		}
+	}
+}
+
+func TestLogicalJar(t *testing.T) {
+	base, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hops := newRequestHops(&resolveOptions{})
+	hops.add("10.0.0.1:80", "svc:80", "svc")
+	hops.add("10.0.0.2:80", "svc:80", "svc")
+	jar := &logicalJar{base: base, hops: hops}
+
+	first, _ := url.Parse("http://10.0.0.1:80/")
+	jar.SetCookies(first, []*Cookie{{Name: "session", Value: "1"}})
+
+	second, _ := url.Parse("http://10.0.0.2:80/")
+	if got := cookieNames(jar.Cookies(second)); got != "session" {
+		t.Errorf("got cookies %q for another endpoint of the service, want session", got)
+	}
+
+	logical, _ := url.Parse("http://svc:80/")
+	if got := cookieNames(base.Cookies(logical)); got != "session" {
+		t.Errorf("got cookies %q stored for the logical host, want session", got)
+	}
+	if got := cookieNames(base.Cookies(first)); got != "" {
+		t.Errorf("got cookies %q stored for the endpoint, want none", got)
+	}
+}
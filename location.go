@@ -0,0 +1,131 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/golang/geo/s2"
+)
+
+const (
+	// ClientLocationHeader is the header gateways usually set with the location of the user a request is done for.
+	// It is only read by clients that have it as their LocationHeader.
+	ClientLocationHeader = "X-Client-Location"
+
+	// DefaultCacheCellLevel is the level of the s2 cells the cache is partitioned by when the client has no
+	// CacheCellLevel. Cells of this level are a few kilometers wide.
+	DefaultCacheCellLevel = 13
+
+	// maxCellLevel is the level of s2 leaf cells.
+	maxCellLevel = 30
+)
+
+var ErrInvalidLocation = errors.New("invalid location")
+
+// ParseLocation parses a location given either as "lat,lng", in degrees, or as an s2 cell token. Its errors never
+// include the value, so that they can be logged without leaking where a user is.
+func ParseLocation(value string) (s2.CellID, error) {
+	value = strings.TrimSpace(value)
+
+	if rawLat, rawLng, ok := strings.Cut(value, ","); ok {
+		lat, err := strconv.ParseFloat(strings.TrimSpace(rawLat), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid latitude", ErrInvalidLocation)
+		}
+
+		lng, err := strconv.ParseFloat(strings.TrimSpace(rawLng), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: invalid longitude", ErrInvalidLocation)
+		}
+
+		latLng, err := validLatLng(lat, lng)
+		if err != nil {
+			return 0, err
+		}
+		return s2.CellIDFromLatLng(latLng), nil
+	}
+
+	cell := s2.CellIDFromToken(value)
+	if !cell.IsValid() {
+		return 0, fmt.Errorf("%w: invalid cell token", ErrInvalidLocation)
+	}
+	return cell, nil
+}
+
+type cellContextKey struct{}
+
+func withCell(ctx context.Context, cell s2.CellID) context.Context {
+	return context.WithValue(ctx, cellContextKey{}, cell)
+}
+
+func cellFromContext(ctx context.Context) (s2.CellID, bool) {
+	cell, ok := ctx.Value(cellContextKey{}).(s2.CellID)
+	return cell, ok
+}
+
+// withHeaderLocation returns a shallow copy of req whose context has the location in the client's LocationHeader, if
+// the client has one, the request has it and its context has no location yet. An invalid location is ignored and
+// the request is resolved for the client's location.
+func (c *Client) withHeaderLocation(req *Request) *Request {
+	if c.LocationHeader == "" {
+		return req
+	}
+
+	if _, ok := cellFromContext(req.Context()); ok {
+		return req
+	}
+
+	value := req.Header.Get(c.LocationHeader)
+	if value == "" {
+		return req
+	}
+
+	cell, err := ParseLocation(value)
+	if err != nil {
+		reqId, _ := RequestIDFromContext(req.Context())
+		c.logger().Warn("ignoring location header", LogFieldReqId, reqId, "header", c.LocationHeader,
+			LogFieldError, err)
+		return req
+	}
+
+	return req.WithContext(withCell(req.Context(), cell))
+}
+
+// locationFor returns the location requests done with ctx are resolved for: the one in ctx if there is one, the
+// client's otherwise.
+func (c *Client) locationFor(ctx context.Context) s2.CellID {
+	if cell, ok := cellFromContext(ctx); ok {
+		return cell
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	return c.location
+}
+
+// cacheKey returns the key of the cache entry for the service at hostPort when resolved for location. Locations in
+// the same cell of the client's CacheCellLevel share their entries.
+func (c *Client) cacheKey(hostPort string, location s2.CellID) addressCacheKey {
+	level := c.CacheCellLevel
+	if level <= 0 || level > maxCellLevel {
+		level = DefaultCacheCellLevel
+	}
+
+	cell := location
+	if cell.IsValid() && cell.Level() > level {
+		cell = cell.Parent(level)
+	}
+	return addressCacheKey{hostPort: hostPort, cell: cell}
+}
+
+// ownCacheKey returns the key of the cache entry for the service at hostPort when resolved for the client's location.
+func (c *Client) ownCacheKey(hostPort string) addressCacheKey {
+	c.RLock()
+	location := c.location
+	c.RUnlock()
+
+	return c.cacheKey(hostPort, location)
+}
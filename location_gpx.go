@@ -0,0 +1,117 @@
+package http
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// DefaultGPXPointInterval is the time between points of a GPX trace that have no timestamps.
+const DefaultGPXPointInterval = time.Second
+
+var ErrEmptyGPX = errors.New("GPX trace has no points")
+
+// GPXReplay is a LocationProvider that replays the track points of a GPX file, e.g. to test how the client behaves
+// while moving. Points are given with the same time between them as in the trace, divided by Speed.
+type GPXReplay struct {
+	Path string
+
+	// Speed makes the trace be replayed faster (above 1) or slower (below 1). If 0, it is replayed in real time.
+	Speed float64
+
+	// Loop makes the trace be replayed again from the start every time it ends, until ctx is done.
+	Loop bool
+}
+
+type (
+	gpxFile struct {
+		Tracks []struct {
+			Segments []struct {
+				Points []gpxPoint `xml:"trkpt"`
+			} `xml:"trkseg"`
+		} `xml:"trk"`
+	}
+
+	gpxPoint struct {
+		Lat  float64   `xml:"lat,attr"`
+		Lon  float64   `xml:"lon,attr"`
+		Time time.Time `xml:"time"`
+	}
+)
+
+// Locations reads the whole trace, failing if it is not a valid GPX file with points, before replaying it.
+func (p *GPXReplay) Locations(ctx context.Context) (<-chan s2.LatLng, error) {
+	points, err := readGPX(p.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	locations := make(chan s2.LatLng)
+	go p.replay(ctx, points, locations)
+	return locations, nil
+}
+
+func readGPX(path string) ([]gpxPoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var gpx gpxFile
+	if err = xml.Unmarshal(data, &gpx); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidLocation, err)
+	}
+
+	var points []gpxPoint
+	for _, track := range gpx.Tracks {
+		for _, segment := range track.Segments {
+			points = append(points, segment.Points...)
+		}
+	}
+
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrEmptyGPX, path)
+	}
+	return points, nil
+}
+
+func (p *GPXReplay) replay(ctx context.Context, points []gpxPoint, locations chan<- s2.LatLng) {
+	defer close(locations)
+
+	speed := p.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	for {
+		for i, point := range points {
+			if i > 0 {
+				interval := DefaultGPXPointInterval
+				if !point.Time.IsZero() && !points[i-1].Time.IsZero() {
+					interval = point.Time.Sub(points[i-1].Time)
+				}
+
+				select {
+				case <-time.After(time.Duration(float64(interval) / speed)):
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case locations <- s2.LatLngFromDegrees(point.Lat, point.Lon):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if !p.Loop {
+			return
+		}
+	}
+}
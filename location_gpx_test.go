@@ -0,0 +1,127 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+const testGPX = `<?xml version="1.0" encoding="UTF-8"?>
+<gpx version="1.1" creator="test">
+  <trk>
+    <trkseg>
+      <trkpt lat="38.7369" lon="-9.1427"><time>2024-01-01T10:00:00Z</time></trkpt>
+      <trkpt lat="38.7400" lon="-9.1500"><time>2024-01-01T10:00:02Z</time></trkpt>
+    </trkseg>
+    <trkseg>
+      <trkpt lat="38.7500" lon="-9.1600"><time>2024-01-01T10:00:04Z</time></trkpt>
+    </trkseg>
+  </trk>
+</gpx>`
+
+var testGPXPoints = []s2.LatLng{
+	s2.LatLngFromDegrees(38.7369, -9.1427),
+	s2.LatLngFromDegrees(38.7400, -9.1500),
+	s2.LatLngFromDegrees(38.7500, -9.1600),
+}
+
+func writeTestFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGPXReplay(t *testing.T) {
+	provider := &GPXReplay{Path: writeTestFile(t, "trace.gpx", testGPX), Speed: 100}
+
+	start := time.Now()
+	locations, err := provider.Locations(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []s2.LatLng
+	for location := range locations {
+		got = append(got, location)
+	}
+
+	if len(got) != len(testGPXPoints) {
+		t.Fatalf("got %d locations, want %d", len(got), len(testGPXPoints))
+	}
+	for i := range got {
+		if !got[i].ApproxEqual(testGPXPoints[i]) {
+			t.Errorf("got location %d at %s, want %s", i, got[i], testGPXPoints[i])
+		}
+	}
+
+	// 4s between the first and last points at 100 times the speed
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("replayed the trace in %s, want about 40ms", elapsed)
+	}
+}
+
+func TestGPXReplayLoop(t *testing.T) {
+	provider := &GPXReplay{Path: writeTestFile(t, "trace.gpx", testGPX), Speed: 1000, Loop: true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	locations, err := provider.Locations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2*len(testGPXPoints); i++ {
+		location, ok := <-locations
+		if !ok {
+			t.Fatalf("trace ended after %d locations", i)
+		}
+		if want := testGPXPoints[i%len(testGPXPoints)]; !location.ApproxEqual(want) {
+			t.Errorf("got location %d at %s, want %s", i, location, want)
+		}
+	}
+
+	cancel()
+	select {
+	case <-locations:
+		// the point being replayed when ctx was done may still be given
+		if _, ok := <-locations; ok {
+			t.Error("kept replaying the trace after ctx was done")
+		}
+	case <-time.After(time.Second):
+		t.Error("kept replaying the trace after ctx was done")
+	}
+}
+
+func TestGPXReplayErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		wantErr  error
+	}{
+		{name: "invalid", contents: "<gpx><trk>", wantErr: ErrInvalidLocation},
+		{name: "no points", contents: `<gpx><trk><trkseg></trkseg></trk></gpx>`, wantErr: ErrEmptyGPX},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &GPXReplay{Path: writeTestFile(t, "trace.gpx", test.contents)}
+			if _, err := provider.Locations(context.Background()); !errors.Is(err, test.wantErr) {
+				t.Errorf("got %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+
+	provider := &GPXReplay{Path: filepath.Join(t.TempDir(), "missing.gpx")}
+	if _, err := provider.Locations(context.Background()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v for a missing file, want %v", err, os.ErrNotExist)
+	}
+}
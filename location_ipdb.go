@@ -0,0 +1,102 @@
+package http
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"strconv"
+
+	"github.com/golang/geo/s2"
+)
+
+var ErrIPNotFound = errors.New("ip not found in database")
+
+// IPDatabase is a LocationProvider that looks the client's public IP up in an offline IP-to-location database, for
+// clients without a GPS receiver. The database is a CSV file whose header names, at least, a network column with
+// CIDR blocks and latitude and longitude columns in degrees, like the GeoLite2 City blocks CSV. The location of the
+// most specific network with IP is given once.
+type IPDatabase struct {
+	Path string
+	IP   netip.Addr
+}
+
+func (p *IPDatabase) Locations(context.Context) (<-chan s2.LatLng, error) {
+	location, err := p.lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	locations := make(chan s2.LatLng, 1)
+	locations <- location
+	close(locations)
+	return locations, nil
+}
+
+// lookup reads the database a row at a time, since they are usually too big to be kept in memory.
+func (p *IPDatabase) lookup() (s2.LatLng, error) {
+	file, err := os.Open(p.Path)
+	if err != nil {
+		return s2.LatLng{}, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return s2.LatLng{}, fmt.Errorf("reading header of %s: %w", p.Path, err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[name] = i
+	}
+
+	networkColumn, hasNetwork := columns["network"]
+	latColumn, hasLat := columns["latitude"]
+	lngColumn, hasLng := columns["longitude"]
+	if !hasNetwork || !hasLat || !hasLng {
+		return s2.LatLng{}, fmt.Errorf("%s has no network, latitude and longitude columns", p.Path)
+	}
+
+	ip := p.IP.Unmap()
+	bestBits := -1
+	var best s2.LatLng
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return s2.LatLng{}, fmt.Errorf("reading %s: %w", p.Path, err)
+		}
+
+		network, err := netip.ParsePrefix(record[networkColumn])
+		if err != nil || network.Bits() <= bestBits || !network.Contains(ip) {
+			continue
+		}
+
+		lat, latErr := strconv.ParseFloat(record[latColumn], 64)
+		lng, lngErr := strconv.ParseFloat(record[lngColumn], 64)
+		if latErr != nil || lngErr != nil {
+			continue
+		}
+
+		location, err := validLatLng(lat, lng)
+		if err != nil {
+			continue
+		}
+
+		best, bestBits = location, network.Bits()
+	}
+
+	if bestBits < 0 {
+		return s2.LatLng{}, fmt.Errorf("%w: %s", ErrIPNotFound, p.IP)
+	}
+	return best, nil
+}
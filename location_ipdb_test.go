@@ -0,0 +1,70 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+const testIPDatabase = `network,geoname_id,latitude,longitude
+10.0.0.0/8,1,38.7369,-9.1427
+10.1.0.0/16,2,41.1496,-8.6109
+10.1.2.0/24,3,not-a-latitude,-8.6109
+10.1.3.0/24,4,123.0,-8.6109
+invalid,5,40.2033,-8.4103
+2001:db8::/32,6,37.0194,-7.9304
+`
+
+func TestIPDatabase(t *testing.T) {
+	path := writeTestFile(t, "blocks.csv", testIPDatabase)
+
+	tests := []struct {
+		name    string
+		ip      string
+		want    s2.LatLng
+		wantErr error
+	}{
+		{name: "network", ip: "10.2.0.1", want: s2.LatLngFromDegrees(38.7369, -9.1427)},
+		{name: "most specific network", ip: "10.1.0.1", want: s2.LatLngFromDegrees(41.1496, -8.6109)},
+		{name: "invalid latitude", ip: "10.1.2.1", want: s2.LatLngFromDegrees(41.1496, -8.6109)},
+		{name: "out of range latitude", ip: "10.1.3.1", want: s2.LatLngFromDegrees(41.1496, -8.6109)},
+		{name: "mapped ipv4", ip: "::ffff:10.2.0.1", want: s2.LatLngFromDegrees(38.7369, -9.1427)},
+		{name: "ipv6", ip: "2001:db8::1", want: s2.LatLngFromDegrees(37.0194, -7.9304)},
+		{name: "not found", ip: "192.168.0.1", wantErr: ErrIPNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider := &IPDatabase{Path: path, IP: netip.MustParseAddr(test.ip)}
+
+			locations, err := provider.Locations(context.Background())
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var got []s2.LatLng
+			for location := range locations {
+				got = append(got, location)
+			}
+			if len(got) != 1 || !got[0].ApproxEqual(test.want) {
+				t.Errorf("got locations %v, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIPDatabaseWithoutColumns(t *testing.T) {
+	provider := &IPDatabase{
+		Path: writeTestFile(t, "blocks.csv", "network,lat,lng\n10.0.0.0/8,38.7369,-9.1427\n"),
+		IP:   netip.MustParseAddr("10.0.0.1"),
+	}
+	if _, err := provider.Locations(context.Background()); err == nil {
+		t.Error("looked up a database without latitude and longitude columns")
+	}
+}
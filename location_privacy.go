@@ -0,0 +1,101 @@
+package http
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sync"
+
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+)
+
+// earthRadiusMeters is the mean radius of the Earth, used to turn jitter distances into angles.
+const earthRadiusMeters = 6371008.8
+
+// LocationPrivacy limits how precisely archimedes, and the traces sent with the client's requests, learn where the
+// client is. The location is first moved by up to MaxJitterMeters, then its cell is truncated to Level and finally
+// coarsened until it holds at least MinPopulation people according to Population. Every step is optional.
+//
+// The location the client is at is still used locally, e.g. to partition the cache, and is never logged.
+type LocationPrivacy struct {
+	// Level is the level the cell sent to archimedes is truncated to. If 0, cells are not truncated.
+	Level int
+
+	// MaxJitterMeters is how far the location may be moved, in a random direction, before being truncated. All the
+	// locations in the same cell at least MaxJitterMeters wide are moved in the same way, so that neither repeated
+	// resolutions nor those of nearby locations can be averaged back to where the client is.
+	MaxJitterMeters float64
+
+	// MinPopulation makes the cell be replaced by its parent while Population tells there are fewer people in it,
+	// in the spirit of k-anonymity. It is ignored if Population is nil.
+	MinPopulation int
+	Population    func(cell s2.CellID) int
+
+	secret     [32]byte
+	secretOnce sync.Once
+}
+
+// apply returns the cell archimedes is told the client is at when it is at cell.
+func (p *LocationPrivacy) apply(cell s2.CellID) s2.CellID {
+	if p == nil || !cell.IsValid() {
+		return cell
+	}
+
+	if p.MaxJitterMeters > 0 {
+		cell = s2.CellIDFromLatLng(p.jitter(cell))
+	}
+
+	if p.Level > 0 && p.Level < cell.Level() {
+		cell = cell.Parent(p.Level)
+	}
+
+	if p.Population != nil {
+		for cell.Level() > 0 && p.Population(cell) < p.MinPopulation {
+			cell = cell.Parent(cell.Level() - 1)
+		}
+	}
+
+	return cell
+}
+
+// jitter moves the center of cell up to MaxJitterMeters away, uniformly over the disk around it. The offset is
+// derived from a secret of its own and the cell at least MaxJitterMeters wide that holds cell, instead of being drawn
+// on every call or for every cell, which would average out as the client moves around.
+func (p *LocationPrivacy) jitter(cell s2.CellID) s2.LatLng {
+	p.secretOnce.Do(func() {
+		if _, err := rand.Read(p.secret[:]); err != nil {
+			panic(err)
+		}
+	})
+
+	seed := cell
+	if level := s2.MinWidthMetric.MaxLevel(p.MaxJitterMeters / earthRadiusMeters); level < seed.Level() {
+		seed = seed.Parent(level)
+	}
+
+	hash := sha256.New()
+	hash.Write(p.secret[:])
+	_ = binary.Write(hash, binary.BigEndian, uint64(seed))
+	sum := hash.Sum(nil)
+
+	u1 := float64(binary.BigEndian.Uint64(sum[:8])) / math.MaxUint64
+	u2 := float64(binary.BigEndian.Uint64(sum[8:16])) / math.MaxUint64
+
+	distance := p.MaxJitterMeters * math.Sqrt(u1) / earthRadiusMeters
+	bearing := 2 * math.Pi * u2
+
+	origin := cell.LatLng()
+	lat1, lng1 := origin.Lat.Radians(), origin.Lng.Radians()
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(distance) + math.Cos(lat1)*math.Sin(distance)*math.Cos(bearing))
+	lng2 := lng1 + math.Atan2(math.Sin(bearing)*math.Sin(distance)*math.Cos(lat1),
+		math.Cos(distance)-math.Sin(lat1)*math.Sin(lat2))
+
+	return s2.LatLng{Lat: s1.Angle(lat2), Lng: s1.Angle(lng2)}.Normalized()
+}
+
+// archimedesLocation returns the cell archimedes, and traces, are told the client is at when it is at location.
+func (c *Client) archimedesLocation(location s2.CellID) s2.CellID {
+	return c.LocationPrivacy.apply(location)
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestLocationPrivacyJitter(t *testing.T) {
+	p := &LocationPrivacy{MaxJitterMeters: 1000}
+	level := s2.MinWidthMetric.MaxLevel(p.MaxJitterMeters / earthRadiusMeters)
+	coarse := s2.CellIDFromLatLng(s2.LatLngFromDegrees(38.7369, -9.1427)).Parent(level)
+
+	// two locations in the same cell at least MaxJitterMeters wide
+	first, second := coarse.ChildBeginAtLevel(maxCellLevel), s2.CellIDFromLatLng(coarse.LatLng())
+
+	offset := func(cell s2.CellID) (float64, float64) {
+		jittered := p.jitter(cell)
+		origin := cell.LatLng()
+		distance := float64(origin.Distance(jittered)) * earthRadiusMeters
+		if distance > p.MaxJitterMeters*1.0001 {
+			t.Errorf("%s moved %.1fm, more than %.0fm", cell.ToToken(), distance, p.MaxJitterMeters)
+		}
+		return (jittered.Lat - origin.Lat).Degrees(), (jittered.Lng - origin.Lng).Degrees()
+	}
+
+	firstLat, firstLng := offset(first)
+	secondLat, secondLng := offset(second)
+	if math.Abs(firstLat-secondLat) > 1e-4 || math.Abs(firstLng-secondLng) > 1e-4 {
+		t.Errorf("nearby locations moved by %v,%v and %v,%v, want the same offset", firstLat, firstLng,
+			secondLat, secondLng)
+	}
+
+	if p.jitter(first) != p.jitter(first) {
+		t.Errorf("the same location moved in different ways")
+	}
+
+	other := &LocationPrivacy{MaxJitterMeters: p.MaxJitterMeters}
+	if p.jitter(first) == other.jitter(first) {
+		t.Errorf("clients with different secrets moved the location in the same way")
+	}
+}
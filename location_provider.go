@@ -0,0 +1,124 @@
+package http
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// DefaultLocationDebounce is how long location updates are coalesced when the client has no LocationDebounce.
+const DefaultLocationDebounce = 5 * time.Second
+
+// LocationProvider gives the location of the client as it changes, e.g. from a GPS receiver.
+type LocationProvider interface {
+	// Locations returns a channel where the provider sends every new location until ctx is done or it has no more,
+	// closing it then. It fails if the provider can not start giving locations at all.
+	Locations(ctx context.Context) (<-chan s2.LatLng, error)
+}
+
+// CellChangePolicy decides what happens to the cached resolutions of the client's cell when its location moves to
+// another cell of the cache.
+type CellChangePolicy int
+
+const (
+	// CellChangeDrop evicts them, so that services are resolved again when next requested.
+	CellChangeDrop CellChangePolicy = iota
+	// CellChangeRefresh resolves them again, in the background, for the new location.
+	CellChangeRefresh
+)
+
+// SubscribeToLocation makes the client follow the locations given by provider, as if SetLocation was called with
+// each of them, until ctx is done or the provider has no more. The first location is used right away and the ones
+// after it are coalesced, the latest being used once every LocationDebounce, so that a noisy provider does not make
+// the client resolve services again on every update.
+func (c *Client) SubscribeToLocation(ctx context.Context, provider LocationProvider) error {
+	locations, err := provider.Locations(ctx)
+	if err != nil {
+		return err
+	}
+
+	go c.followLocations(locations)
+	return nil
+}
+
+func (c *Client) followLocations(locations <-chan s2.LatLng) {
+	debounce := c.LocationDebounce
+	if debounce <= 0 {
+		debounce = DefaultLocationDebounce
+	}
+
+	var (
+		pending   *s2.LatLng
+		debounced <-chan time.Time
+	)
+
+	for {
+		select {
+		case location, ok := <-locations:
+			if !ok {
+				if pending != nil {
+					c.SetLocation(*pending)
+				}
+				c.logger().Debug("location provider has no more locations")
+				return
+			}
+
+			if debounced == nil {
+				c.SetLocation(location)
+				debounced = time.After(debounce)
+				continue
+			}
+			pending = &location
+		case <-debounced:
+			debounced = nil
+			if pending != nil {
+				c.SetLocation(*pending)
+				pending = nil
+				debounced = time.After(debounce)
+			}
+		}
+	}
+}
+
+// leaveCell drops or refreshes, according to the client's CellChangePolicy, the cache entries of the cell the client
+// left. Watched services are left out, since they are always resolved again when the location changes, and so are
+// the entries resolved for the location of a request, which are still right for requests from that location.
+func (c *Client) leaveCell(cell s2.CellID) {
+	c.cache.Range(func(key, value interface{}) bool {
+		cacheKey := key.(addressCacheKey)
+		if cacheKey.cell != cell || !value.(addressCacheValue).clientLocation {
+			return true
+		}
+
+		c.cache.Delete(cacheKey)
+		if c.CellChangePolicy == CellChangeRefresh && !c.isWatched(cacheKey.hostPort) {
+			go c.refreshEntry(cacheKey.hostPort, value.(addressCacheValue))
+		}
+		return true
+	})
+}
+
+// refreshEntry resolves the service of a cache entry of another cell for the client's location.
+func (c *Client) refreshEntry(hostPort string, entry *cacheEntry) {
+	_, _, err := c.resolveServiceInArchimedes(context.Background(), hostPort, c.resolveOptionsForEntry(entry))
+	if err != nil {
+		c.logger().Warn("error refreshing cache entry for new cell", LogFieldHost, hostPort, LogFieldError, err)
+	}
+}
+
+type staticLocation struct {
+	location s2.LatLng
+}
+
+// StaticLocation returns a LocationProvider that always gives location.
+func StaticLocation(location s2.LatLng) LocationProvider {
+	return &staticLocation{location: location}
+}
+
+func (p *staticLocation) Locations(context.Context) (<-chan s2.LatLng, error) {
+	locations := make(chan s2.LatLng, 1)
+	locations <- p.location
+	close(locations)
+	return locations, nil
+}
@@ -0,0 +1,85 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestLeaveCellKeepsRequestLocationEntries(t *testing.T) {
+	c := &Client{initialized: true}
+	c.SetLocation(s2.LatLngFromDegrees(38.7369, -9.1427))
+	cell := c.ownCacheKey("").cell
+
+	own := newCacheEntry("10.0.0.1:80", "d1", "tcp")
+	own.clientLocation = true
+	c.cache.Store(c.ownCacheKey("own:80"), own)
+	c.cache.Store(c.ownCacheKey("gateway:80"), newCacheEntry("10.0.0.2:80", "d2", "tcp"))
+
+	c.leaveCell(cell)
+
+	if _, ok := c.cache.Load(c.ownCacheKey("own:80")); ok {
+		t.Error("kept the client's entry of the cell it left")
+	}
+	if _, ok := c.cache.Load(c.ownCacheKey("gateway:80")); !ok {
+		t.Error("dropped the entry resolved for the location of a request")
+	}
+}
+
+func TestFollowLocationsDebounce(t *testing.T) {
+	c := &Client{initialized: true, LocationDebounce: 100 * time.Millisecond}
+	location := func() s2.CellID {
+		c.RLock()
+		defer c.RUnlock()
+		return c.location
+	}
+	waitForLocation := func(want s2.LatLng) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for location() != s2.CellIDFromLatLng(want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("the client is at %s, want %s", location().LatLng(), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	first := s2.LatLngFromDegrees(38.7369, -9.1427)
+	second := s2.LatLngFromDegrees(41.1496, -8.6109)
+	third := s2.LatLngFromDegrees(40.2033, -8.4103)
+	last := s2.LatLngFromDegrees(37.0194, -7.9304)
+
+	locations := make(chan s2.LatLng)
+	done := make(chan struct{})
+	go func() {
+		c.followLocations(locations)
+		close(done)
+	}()
+
+	// the first location is used right away and the ones after it only once the debounce ends
+	locations <- first
+	waitForLocation(first)
+	start := time.Now()
+	locations <- second
+	locations <- third
+	if time.Since(start) < c.LocationDebounce && location() != s2.CellIDFromLatLng(first) {
+		t.Errorf("used a location before the debounce ended")
+	}
+	waitForLocation(third)
+	if elapsed := time.Since(start); elapsed < c.LocationDebounce/2 {
+		t.Errorf("used the latest location after %s, want about %s", elapsed, c.LocationDebounce)
+	}
+
+	// the latest location is used when the provider has no more, even during a debounce
+	locations <- last
+	close(locations)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("kept following locations after the provider had no more")
+	}
+	if location() != s2.CellIDFromLatLng(last) {
+		t.Errorf("the client is at %s, want %s", location().LatLng(), last)
+	}
+}
@@ -0,0 +1,232 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// DefaultGPSStreamPollInterval is how long a GPSStream waits for more fixes once it reads everything in its file.
+const DefaultGPSStreamPollInterval = 500 * time.Millisecond
+
+var ErrNoFix = errors.New("no fix")
+
+// GPSStream is a LocationProvider that reads GPS fixes, one per line, from a file or a named pipe that some GPS
+// daemon keeps writing to. Lines can be either NMEA 0183 sentences (GGA and RMC, from any talker) or JSON objects with
+// lat and lng (or lon) in degrees; other lines are skipped. Like tail -f, the file is read until ctx is done, waiting
+// for more lines whenever its end is reached.
+type GPSStream struct {
+	Path string
+
+	// PollInterval is how long to wait for more lines once the end of the file is reached. If 0,
+	// DefaultGPSStreamPollInterval is used.
+	PollInterval time.Duration
+
+	// Logger receives the errors reading the file. If nil, they are written to the standard logrus logger.
+	Logger Logger
+}
+
+func (p *GPSStream) Locations(ctx context.Context) (<-chan s2.LatLng, error) {
+	if _, err := os.Stat(p.Path); err != nil {
+		return nil, err
+	}
+
+	locations := make(chan s2.LatLng)
+	go p.read(ctx, locations)
+	return locations, nil
+}
+
+func (p *GPSStream) read(ctx context.Context, locations chan<- s2.LatLng) {
+	defer close(locations)
+
+	logger := p.Logger
+	if logger == nil {
+		logger = defaultLogger
+	}
+
+	pollInterval := p.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultGPSStreamPollInterval
+	}
+
+	file, err := openGPSStream(p.Path)
+	if err != nil {
+		logger.Error("error opening GPS stream", "path", p.Path, LogFieldError, err)
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	var partial string
+	for {
+		line, err := reader.ReadString('\n')
+		partial += line
+		switch {
+		case errors.Is(err, io.EOF):
+			select {
+			case <-time.After(pollInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		case err != nil:
+			if ctx.Err() == nil {
+				logger.Error("error reading GPS stream", "path", p.Path, LogFieldError, err)
+			}
+			return
+		}
+
+		line, partial = strings.TrimSpace(partial), ""
+		location, err := ParseGPSFix(line)
+		if err != nil {
+			logger.Debug("skipping GPS stream line", "path", p.Path, LogFieldError, err)
+			continue
+		}
+
+		select {
+		case locations <- location:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// ParseGPSFix parses a GPS fix given either as an NMEA 0183 GGA or RMC sentence or as a JSON object with lat and lng
+// (or lon) in degrees. Sentences without a valid fix fail with ErrNoFix.
+func ParseGPSFix(line string) (s2.LatLng, error) {
+	switch {
+	case strings.HasPrefix(line, "$"):
+		return parseNMEA(line)
+	case strings.HasPrefix(line, "{"):
+		return parseJSONFix(line)
+	default:
+		return s2.LatLng{}, fmt.Errorf("%w: unknown GPS fix format", ErrInvalidLocation)
+	}
+}
+
+func parseJSONFix(line string) (s2.LatLng, error) {
+	var fix struct {
+		Lat *float64 `json:"lat"`
+		Lng *float64 `json:"lng"`
+		Lon *float64 `json:"lon"`
+	}
+	if err := json.Unmarshal([]byte(line), &fix); err != nil {
+		return s2.LatLng{}, fmt.Errorf("%w: %s", ErrInvalidLocation, err)
+	}
+
+	if fix.Lng == nil {
+		fix.Lng = fix.Lon
+	}
+	if fix.Lat == nil || fix.Lng == nil {
+		return s2.LatLng{}, fmt.Errorf("%w: GPS fix has no lat and lng", ErrInvalidLocation)
+	}
+
+	return validLatLng(*fix.Lat, *fix.Lng)
+}
+
+// parseNMEA parses GGA and RMC sentences, checking their checksum when they have one.
+func parseNMEA(sentence string) (s2.LatLng, error) {
+	sentence = strings.TrimPrefix(sentence, "$")
+	if data, checksum, ok := strings.Cut(sentence, "*"); ok {
+		expected, err := strconv.ParseUint(checksum, 16, 8)
+		if err != nil {
+			return s2.LatLng{}, fmt.Errorf("%w: invalid NMEA checksum %q", ErrInvalidLocation, checksum)
+		}
+
+		var sum byte
+		for i := 0; i < len(data); i++ {
+			sum ^= data[i]
+		}
+		if uint64(sum) != expected {
+			return s2.LatLng{}, fmt.Errorf("%w: NMEA checksum mismatch", ErrInvalidLocation)
+		}
+		sentence = data
+	}
+
+	fields := strings.Split(sentence, ",")
+	if len(fields[0]) != 5 {
+		return s2.LatLng{}, fmt.Errorf("%w: invalid NMEA sentence %q", ErrInvalidLocation, fields[0])
+	}
+
+	var latFields, lngFields []string
+	switch fields[0][2:] {
+	case "GGA":
+		if len(fields) < 7 {
+			return s2.LatLng{}, fmt.Errorf("%w: short GGA sentence", ErrInvalidLocation)
+		}
+		if fields[6] == "" || fields[6] == "0" {
+			return s2.LatLng{}, ErrNoFix
+		}
+		latFields, lngFields = fields[2:4], fields[4:6]
+	case "RMC":
+		if len(fields) < 7 {
+			return s2.LatLng{}, fmt.Errorf("%w: short RMC sentence", ErrInvalidLocation)
+		}
+		if fields[2] != "A" {
+			return s2.LatLng{}, ErrNoFix
+		}
+		latFields, lngFields = fields[3:5], fields[5:7]
+	default:
+		return s2.LatLng{}, fmt.Errorf("%w: unsupported NMEA sentence %q", ErrInvalidLocation, fields[0])
+	}
+
+	lat, err := parseNMEACoordinate(latFields[0], latFields[1], 2, "N", "S")
+	if err != nil {
+		return s2.LatLng{}, err
+	}
+
+	lng, err := parseNMEACoordinate(lngFields[0], lngFields[1], 3, "E", "W")
+	if err != nil {
+		return s2.LatLng{}, err
+	}
+
+	return validLatLng(lat, lng)
+}
+
+// parseNMEACoordinate parses a coordinate in the NMEA (d)ddmm.mmmm format, whose degrees take degreeDigits digits.
+func parseNMEACoordinate(value, hemisphere string, degreeDigits int, positive, negative string) (float64, error) {
+	if len(value) < degreeDigits {
+		return 0, fmt.Errorf("%w: invalid NMEA coordinate", ErrInvalidLocation)
+	}
+
+	degrees, err := strconv.ParseFloat(value[:degreeDigits], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid NMEA coordinate", ErrInvalidLocation)
+	}
+
+	minutes, err := strconv.ParseFloat(value[degreeDigits:], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid NMEA coordinate", ErrInvalidLocation)
+	}
+
+	coordinate := degrees + minutes/60
+	switch hemisphere {
+	case positive:
+		return coordinate, nil
+	case negative:
+		return -coordinate, nil
+	default:
+		return 0, fmt.Errorf("%w: invalid NMEA hemisphere %q", ErrInvalidLocation, hemisphere)
+	}
+}
+
+func validLatLng(lat, lng float64) (s2.LatLng, error) {
+	latLng := s2.LatLngFromDegrees(lat, lng)
+	if !latLng.IsValid() {
+		return s2.LatLng{}, fmt.Errorf("%w: coordinates out of range", ErrInvalidLocation)
+	}
+	return latLng, nil
+}
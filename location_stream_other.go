@@ -0,0 +1,11 @@
+//go:build !unix
+
+package http
+
+import "os"
+
+// openGPSStream opens the file of a GPSStream. Named pipes, which would block until they have a writer, are only
+// opened without blocking on unix, so elsewhere the file is opened as is.
+func openGPSStream(path string) (*os.File, error) {
+	return os.Open(path)
+}
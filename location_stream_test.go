@@ -0,0 +1,72 @@
+package http
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseGPSFix(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		lat, lng float64
+		wantErr  error
+	}{
+		{
+			name: "GGA",
+			line: "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*47",
+			lat:  48.1173, lng: 11.516667,
+		},
+		{
+			name: "RMC from another talker",
+			line: "$GNRMC,123519,A,3844.214,N,00908.562,W,022.4,084.4,230394,003.1,W",
+			lat:  38.736900, lng: -9.142700,
+		},
+		{name: "GGA without a fix", line: "$GPGGA,123519,,,,,0,00,,,M,,M,,", wantErr: ErrNoFix},
+		{name: "RMC without a fix", line: "$GPRMC,123519,V,,,,,,,230394,,", wantErr: ErrNoFix},
+		{
+			name:    "checksum mismatch",
+			line:    "$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,*48",
+			wantErr: ErrInvalidLocation,
+		},
+		{name: "invalid checksum", line: "$GPGGA,123519*ZZ", wantErr: ErrInvalidLocation},
+		{name: "unsupported sentence", line: "$GPGSV,3,1,11", wantErr: ErrInvalidLocation},
+		{name: "short sentence", line: "$GPGGA,123519", wantErr: ErrInvalidLocation},
+		{
+			name:    "invalid hemisphere",
+			line:    "$GPGGA,123519,4807.038,X,01131.000,E,1,08,0.9,545.4,M,46.9,M,,",
+			wantErr: ErrInvalidLocation,
+		},
+		{
+			name:    "invalid coordinate",
+			line:    "$GPGGA,123519,48a7.038,N,01131.000,E,1,08,0.9,545.4,M,46.9,M,,",
+			wantErr: ErrInvalidLocation,
+		},
+		{name: "JSON lat and lng", line: `{"lat": 38.7369, "lng": -9.1427}`, lat: 38.7369, lng: -9.1427},
+		{name: "JSON lat and lon", line: `{"lat": 38.7369, "lon": -9.1427}`, lat: 38.7369, lng: -9.1427},
+		{name: "JSON without lng", line: `{"lat": 38.7369}`, wantErr: ErrInvalidLocation},
+		{name: "JSON out of range", line: `{"lat": 91, "lng": 0}`, wantErr: ErrInvalidLocation},
+		{name: "invalid JSON", line: `{"lat":`, wantErr: ErrInvalidLocation},
+		{name: "unknown format", line: "38.7369,-9.1427", wantErr: ErrInvalidLocation},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseGPSFix(test.line)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("got %v, %v, want %v", got, err, test.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got.Lat.Degrees()-test.lat) > 1e-6 || math.Abs(got.Lng.Degrees()-test.lng) > 1e-6 {
+				t.Errorf("got %v, want %v,%v", got, test.lat, test.lng)
+			}
+		})
+	}
+}
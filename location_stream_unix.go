@@ -0,0 +1,15 @@
+//go:build unix
+
+package http
+
+import (
+	"os"
+	"syscall"
+)
+
+// openGPSStream opens the file of a GPSStream. Opening a named pipe blocks until it has a writer, which could be
+// never, so it is opened without blocking. Reading it then gives EOF until a writer appears and closing the file is
+// what unblocks reading it when the stream is done.
+func openGPSStream(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+}
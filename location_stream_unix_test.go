@@ -0,0 +1,71 @@
+//go:build unix
+
+package http
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGPSStreamNamedPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gps")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &GPSStream{Path: path, PollInterval: 10 * time.Millisecond}
+	locations, err := stream.Locations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writer, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	if _, err = writer.WriteString(`{"lat": 38.7369, "lng": -9.1427}` + "\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case location := <-locations:
+		if lat := location.Lat.Degrees(); lat < 38.7 || lat > 38.8 {
+			t.Errorf("got location %v", location)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no location read from the named pipe")
+	}
+}
+
+func TestGPSStreamNamedPipeWithoutWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gps")
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &GPSStream{Path: path, PollInterval: 10 * time.Millisecond}
+	locations, err := stream.Locations(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-locations:
+		if ok {
+			t.Error("got a location from a named pipe without writer")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GPS stream still waiting for a writer after ctx was done")
+	}
+}
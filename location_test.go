@@ -0,0 +1,49 @@
+package http
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestParseLocation(t *testing.T) {
+	lisbon := s2.CellIDFromLatLng(s2.LatLngFromDegrees(38.7369, -9.1427))
+
+	tests := []struct {
+		name    string
+		value   string
+		want    s2.CellID
+		wantErr bool
+	}{
+		{name: "lat,lng", value: "38.7369,-9.1427", want: lisbon},
+		{name: "lat,lng with spaces", value: " 38.7369 , -9.1427 ", want: lisbon},
+		{name: "leaf cell token", value: lisbon.ToToken(), want: lisbon},
+		{name: "coarse cell token", value: lisbon.Parent(10).ToToken(), want: lisbon.Parent(10)},
+		{name: "invalid latitude", value: "north,-9.1427", wantErr: true},
+		{name: "invalid longitude", value: "38.7369,", wantErr: true},
+		{name: "latitude out of range", value: "91,0", wantErr: true},
+		{name: "longitude out of range", value: "0,181", wantErr: true},
+		{name: "invalid token", value: "not-a-token", wantErr: true},
+		{name: "empty", value: "", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseLocation(test.value)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidLocation) {
+					t.Errorf("got %v, %v, want ErrInvalidLocation", got, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("got %s, want %s", got.ToToken(), test.want.ToToken())
+			}
+		})
+	}
+}
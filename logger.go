@@ -0,0 +1,86 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Keys of the structured fields the client logs with.
+const (
+	LogFieldReqId      = "req_id"
+	LogFieldDeployment = "deployment"
+	LogFieldEndpoint   = "endpoint"
+	LogFieldHost       = "host"
+	LogFieldArchimedes = "archimedes"
+	LogFieldError      = "error"
+)
+
+// Logger is where a Client writes its logs. Messages are constant and what changes from one message to the next is
+// passed as structured fields in keysAndValues, alternating keys and values like in log/slog, e.g.
+//
+//	logger.Debug("resolved using cache", LogFieldHost, hostPort, LogFieldEndpoint, resolvedHostPort)
+//
+// Implementations must be safe for concurrent use. NewLogrusLogger and NewSlogLogger adapt the loggers of those
+// libraries and the zaplog package adapts zap loggers.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+// defaultLogger is used when no Logger is set. It writes to the standard logrus logger, as the client always did.
+var defaultLogger Logger = NewLogrusLogger(logrus.StandardLogger())
+
+func (c *Client) logger() Logger {
+	if c.Logger == nil {
+		return defaultLogger
+	}
+	return c.Logger
+}
+
+type logrusLogger struct {
+	logger logrus.FieldLogger
+}
+
+// NewLogrusLogger adapts a logrus logger, or an entry that already has some fields, to Logger. The structured fields
+// become logrus fields.
+func NewLogrusLogger(logger logrus.FieldLogger) Logger {
+	return &logrusLogger{logger: logger}
+}
+
+func (l *logrusLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.WithFields(logrusFields(keysAndValues)).Debug(msg)
+}
+
+func (l *logrusLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.WithFields(logrusFields(keysAndValues)).Info(msg)
+}
+
+func (l *logrusLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.WithFields(logrusFields(keysAndValues)).Warn(msg)
+}
+
+func (l *logrusLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.WithFields(logrusFields(keysAndValues)).Error(msg)
+}
+
+// logrusFields turns alternating keys and values into logrus fields. Keys that are not strings are formatted and a
+// trailing key without a value is logged with a nil one.
+func logrusFields(keysAndValues []interface{}) logrus.Fields {
+	fields := make(logrus.Fields, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, ok := keysAndValues[i].(string)
+		if !ok {
+			key = fmt.Sprint(keysAndValues[i])
+		}
+
+		var value interface{}
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fields[key] = value
+	}
+	return fields
+}
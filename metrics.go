@@ -0,0 +1,71 @@
+package http
+
+import (
+	"time"
+)
+
+// ResolutionOutcome is how a resolution in archimedes ended.
+type ResolutionOutcome string
+
+const (
+	ResolutionFound    ResolutionOutcome = "found"
+	ResolutionNotFound ResolutionOutcome = "not_found"
+	ResolutionError    ResolutionOutcome = "error"
+)
+
+func resolutionOutcome(found bool, err error) ResolutionOutcome {
+	switch {
+	case err != nil:
+		return ResolutionError
+	case !found:
+		return ResolutionNotFound
+	default:
+		return ResolutionFound
+	}
+}
+
+// ServerSwitchReason is why the client changed its primary archimedes server.
+type ServerSwitchReason string
+
+const (
+	// SwitchAdopted means the client adopted a closer archimedes node that answered a redirected resolution.
+	SwitchAdopted ServerSwitchReason = "adopted"
+	// SwitchFallback means the client was reset to its fallback archimedes server.
+	SwitchFallback ServerSwitchReason = "fallback"
+)
+
+// Metrics receives the measurements of a Client. Implementations must be safe for concurrent use, since they are
+// called from every request. The prommetrics package has the implementation for Prometheus; others can be plugged
+// in by implementing this interface.
+type Metrics interface {
+	// CacheLookup is called once per request with how its endpoint was found.
+	CacheLookup(status CacheStatus)
+	// Resolution is called for every resolution in archimedes with how it ended and how long it took.
+	Resolution(outcome ResolutionOutcome, latency time.Duration)
+	// ArchimedesServerSwitch is called whenever the primary archimedes server changes.
+	ArchimedesServerSwitch(reason ServerSwitchReason)
+	// Reresolution is called when a service is resolved again because its cached endpoint failed.
+	Reresolution(deploymentId string)
+	// GeofenceViolation is called whenever a service of deploymentId is resolved to an endpoint outside its geofence,
+	// or whose location is unknown, with what the client did about it.
+	GeofenceViolation(deploymentId string, action GeofenceAction)
+	// Request is called once per request, when it finishes, with its status code (0 if it failed) and how long it
+	// took, resolution included.
+	Request(deploymentId string, statusCode int, latency time.Duration)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) CacheLookup(CacheStatus)                     {}
+func (noopMetrics) Resolution(ResolutionOutcome, time.Duration) {}
+func (noopMetrics) ArchimedesServerSwitch(ServerSwitchReason)   {}
+func (noopMetrics) Reresolution(string)                         {}
+func (noopMetrics) GeofenceViolation(string, GeofenceAction)    {}
+func (noopMetrics) Request(string, int, time.Duration)          {}
+
+func (c *Client) metrics() Metrics {
+	if c.Metrics == nil {
+		return noopMetrics{}
+	}
+	return c.Metrics
+}
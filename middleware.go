@@ -0,0 +1,278 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+)
+
+// ChainMiddlewareFunc is a middleware that runs synchronously, in priority order, as part of the chain every request
+// goes through. It may modify the request. Returning a non-nil response short-circuits the request: the remaining
+// middlewares are skipped and the response is returned to the caller without sending anything. Returning a non-nil
+// error aborts the request with that error.
+type ChainMiddlewareFunc = func(reqId string, req *Request) (*Response, error)
+
+// DefaultMiddlewarePriority is the priority of the middlewares registered with RegisterMiddleware.
+const DefaultMiddlewarePriority = 0
+
+var (
+	ErrInvalidMiddleware  = errors.New("invalid middleware")
+	ErrMiddlewareNotFound = errors.New("middleware not found")
+)
+
+// MiddlewareScope restricts a middleware to the requests for some services. A request is in scope if its deployment
+// is one of DeploymentIds or its host (without port) matches HostPattern, with the syntax of path.Match. Empty fields
+// match nothing, so a scope with both empty matches no request.
+type MiddlewareScope struct {
+	DeploymentIds []string `json:"deployment_ids,omitempty"`
+	HostPattern   string   `json:"host_pattern,omitempty"`
+}
+
+// scopeTarget is what a request is matched against to decide whether a scoped middleware runs for it.
+type scopeTarget struct {
+	host         string
+	deploymentId string
+}
+
+func (s *MiddlewareScope) matches(target scopeTarget) bool {
+	if s == nil {
+		return true
+	}
+
+	for _, deploymentId := range s.DeploymentIds {
+		if deploymentId == target.deploymentId {
+			return true
+		}
+	}
+
+	if s.HostPattern != "" {
+		matched, _ := path.Match(s.HostPattern, target.host)
+		return matched
+	}
+
+	return false
+}
+
+// scopeTargetFor returns what the middlewares scope is matched against for a request to the given logical host.
+func scopeTargetFor(logical string, deploymentIdFunc DeploymentIDFunc) (scopeTarget, error) {
+	host := hostWithoutPort(logical)
+	deploymentId, err := deploymentIdFor(deploymentIdFunc, host)
+	if err != nil {
+		return scopeTarget{}, err
+	}
+	return scopeTarget{host: host, deploymentId: deploymentId}, nil
+}
+
+type (
+	middleware struct {
+		id       string
+		priority int
+		observer MiddlewareFunc
+		chain    ChainMiddlewareFunc
+		response ResponseMiddlewareFunc
+		scope    *MiddlewareScope
+	}
+
+	// middlewareChain holds the middlewares of one phase (before resolving, after resolving or response), sorted by
+	// priority. Middlewares with the same priority keep their registration order.
+	middlewareChain struct {
+		middlewares []*middleware
+		sync.RWMutex
+	}
+)
+
+func (m *middleware) kind() string {
+	switch {
+	case m.observer != nil:
+		return "observer"
+	case m.chain != nil:
+		return "chain"
+	default:
+		return "response"
+	}
+}
+
+// upsert adds mid to the chain, replacing the middleware with the same id if there is one. The replaced
+// middleware's scope is kept.
+func (m *middlewareChain) upsert(mid *middleware) (replaced bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	for i, existing := range m.middlewares {
+		if existing.id == mid.id {
+			mid.scope = existing.scope
+			m.middlewares = append(m.middlewares[:i], m.middlewares[i+1:]...)
+			replaced = true
+			break
+		}
+	}
+
+	m.middlewares = append(m.middlewares, mid)
+	sort.SliceStable(m.middlewares, func(i, j int) bool {
+		return m.middlewares[i].priority < m.middlewares[j].priority
+	})
+
+	return replaced
+}
+
+func (m *middlewareChain) remove(midId string) (removed bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	for i, existing := range m.middlewares {
+		if existing.id == midId {
+			m.middlewares = append(m.middlewares[:i], m.middlewares[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// setScope changes the scope of the middleware with id midId. The middleware is copied, so that requests already
+// running the chain keep seeing the old scope.
+func (m *middlewareChain) setScope(midId string, scope *MiddlewareScope) (found bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	for i, existing := range m.middlewares {
+		if existing.id == midId {
+			scoped := *existing
+			scoped.scope = scope
+			m.middlewares[i] = &scoped
+			return true
+		}
+	}
+
+	return false
+}
+
+func (m *middlewareChain) snapshot() []*middleware {
+	m.RLock()
+	defer m.RUnlock()
+
+	middlewares := make([]*middleware, len(m.middlewares))
+	copy(middlewares, m.middlewares)
+	return middlewares
+}
+
+// RegisterChainMiddleware registers a middleware with id midId and a function midFunc that is ran synchronously
+// everytime a request is done, after every middleware with a lower priority. If afterResolving is true the function
+// is called with the request after its url was resolved through archimedes, otherwise with the original request.
+// If a middleware with the same id was already registered for the same phase it is replaced.
+//
+// Unlike the middlewares registered with RegisterMiddleware, midFunc may change the request, short-circuit it with a
+// response or abort it with an error.
+func (c *Client) RegisterChainMiddleware(midId string, priority int, midFunc ChainMiddlewareFunc,
+	afterResolving bool) error {
+	if midFunc == nil {
+		return fmt.Errorf("%w: middleware %s has no function", ErrInvalidMiddleware, midId)
+	}
+
+	return c.registerMiddleware(&middleware{id: midId, priority: priority, chain: midFunc}, c.phase(afterResolving))
+}
+
+// UnregisterMiddleware removes the middleware with id midId from every phase it was registered for. It returns
+// whether there was any.
+func (c *Client) UnregisterMiddleware(midId string) bool {
+	removed := false
+	for _, chain := range c.middlewareChains() {
+		removed = chain.remove(midId) || removed
+	}
+
+	if removed {
+		c.logger().Debug("unregistered middleware", "middleware", midId)
+	}
+	return removed
+}
+
+// ScopeMiddleware restricts the middleware with id midId to the requests in scope, in every phase it was registered
+// for. A nil scope makes the middleware run for every request again. Scopes survive the middleware being replaced.
+func (c *Client) ScopeMiddleware(midId string, scope *MiddlewareScope) error {
+	if scope != nil && scope.HostPattern != "" {
+		if _, err := path.Match(scope.HostPattern, ""); err != nil {
+			return fmt.Errorf("%w: %s", err, scope.HostPattern)
+		}
+	}
+
+	found := false
+	for _, chain := range c.middlewareChains() {
+		found = chain.setScope(midId, scope) || found
+	}
+
+	if !found {
+		return fmt.Errorf("%w: %s", ErrMiddlewareNotFound, midId)
+	}
+
+	c.logger().Debug("scoped middleware", "middleware", midId, "scope", scope)
+	return nil
+}
+
+func (c *Client) phase(afterResolving bool) *middlewareChain {
+	if afterResolving {
+		return &c.afterMiddlewares
+	}
+	return &c.beforeMiddlewares
+}
+
+func (c *Client) middlewareChains() []*middlewareChain {
+	return []*middlewareChain{&c.beforeMiddlewares, &c.afterMiddlewares, &c.responseMiddlewares}
+}
+
+func (c *Client) registerMiddleware(mid *middleware, chain *middlewareChain) error {
+	if mid.id == "" {
+		return fmt.Errorf("%w: middleware has no id", ErrInvalidMiddleware)
+	}
+
+	if chain.upsert(mid) {
+		c.logger().Debug("replaced middleware", "middleware", mid.id, "priority", mid.priority)
+	} else {
+		c.logger().Debug("registered middleware", "middleware", mid.id, "priority", mid.priority)
+	}
+	return nil
+}
+
+// runMiddlewares runs the middlewares in chain that are in scope for target in order. Observers are started in their
+// own goroutine and never wait, chain middlewares run to completion before the next one starts. Observers are given a
+// copy of the request as it was when they were reached, since the request keeps being changed and sent while they
+// run.
+func (c *Client) runMiddlewares(chain *middlewareChain, target scopeTarget, reqId string, req *Request) (*Response,
+	error) {
+	var observed *Request
+	for _, mid := range chain.snapshot() {
+		if !mid.scope.matches(target) {
+			continue
+		}
+
+		c.logger().Debug("calling middleware", LogFieldReqId, reqId, "middleware", mid.id)
+
+		if mid.observer != nil {
+			if observed == nil {
+				observed = req.Clone(req.Context())
+			}
+			go mid.observer(reqId, observed)
+			continue
+		}
+
+		// a chain middleware may change the request, so the observers after it need a new copy
+		observed = nil
+		resp, err := mid.chain(reqId, req)
+		if err != nil {
+			c.logger().Debug("middleware aborted request", LogFieldReqId, reqId, "middleware", mid.id,
+				LogFieldError, err)
+			return nil, err
+		}
+
+		if resp != nil {
+			c.logger().Debug("middleware short-circuited request", LogFieldReqId, reqId, "middleware", mid.id)
+			if resp.Request == nil {
+				resp.Request = req
+			}
+			return resp, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,447 @@
+package http
+
+import (
+	"errors"
+	"io"
+	originalHttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testMiddleware is a chain middleware registered by the middleware tests, which records that it ran and then does
+// what its result says: nothing, respond or fail.
+type testMiddleware struct {
+	id             string
+	priority       int
+	afterResolving bool
+	result         string
+	scope          *MiddlewareScope
+}
+
+const (
+	resultContinue = ""
+	resultRespond  = "respond"
+	resultFail     = "fail"
+)
+
+var errMiddleware = errors.New("middleware failed")
+
+// middlewareRecorder records the ids of the middlewares that ran, in order.
+type middlewareRecorder struct {
+	ran []string
+	sync.Mutex
+}
+
+func (r *middlewareRecorder) record(id string) {
+	r.Lock()
+	defer r.Unlock()
+	r.ran = append(r.ran, id)
+}
+
+func (r *middlewareRecorder) String() string {
+	r.Lock()
+	defer r.Unlock()
+	return strings.Join(r.ran, ",")
+}
+
+func (m testMiddleware) register(t *testing.T, c *Client, recorder *middlewareRecorder) {
+	t.Helper()
+
+	midFunc := func(reqId string, req *Request) (*Response, error) {
+		recorder.record(m.id)
+
+		switch m.result {
+		case resultRespond:
+			return &Response{StatusCode: StatusTeapot, Body: io.NopCloser(strings.NewReader(m.id))}, nil
+		case resultFail:
+			return nil, errMiddleware
+		default:
+			return nil, nil
+		}
+	}
+
+	if err := c.RegisterChainMiddleware(m.id, m.priority, midFunc, m.afterResolving); err != nil {
+		t.Fatal(err)
+	}
+	if m.scope != nil {
+		if err := c.ScopeMiddleware(m.id, m.scope); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newMiddlewareTestClient returns a client whose archimedes resolves svc and other-svc to a server that counts the
+// requests it gets.
+func newMiddlewareTestClient(t *testing.T) (*Client, *int32) {
+	var requests int32
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {
+		atomic.AddInt32(&requests, 1)
+	}))
+	t.Cleanup(server.Close)
+
+	endpoint := server.Listener.Addr().String()
+	c := newFakeArchimedesClient(&fakeArchimedes{endpoints: map[string]string{"svc": endpoint,
+		"other-svc": endpoint}})
+	return c, &requests
+}
+
+func TestChainMiddlewares(t *testing.T) {
+	tests := []struct {
+		name         string
+		url          string
+		middlewares  []testMiddleware
+		wantRan      string
+		wantStatus   int
+		wantErr      error
+		wantUpstream bool
+	}{
+		{
+			name: "priority order",
+			middlewares: []testMiddleware{
+				{id: "late", priority: 10},
+				{id: "early", priority: -5},
+				{id: "default-1"},
+				{id: "default-2"},
+			},
+			wantRan:      "early,default-1,default-2,late",
+			wantStatus:   StatusOK,
+			wantUpstream: true,
+		},
+		{
+			name: "before resolving runs first",
+			middlewares: []testMiddleware{
+				{id: "after", priority: -10, afterResolving: true},
+				{id: "before", priority: 10},
+			},
+			wantRan:      "before,after",
+			wantStatus:   StatusOK,
+			wantUpstream: true,
+		},
+		{
+			name: "short-circuit before resolving",
+			middlewares: []testMiddleware{
+				{id: "respond", result: resultRespond},
+				{id: "skipped", priority: 1},
+				{id: "after", afterResolving: true},
+			},
+			wantRan:    "respond",
+			wantStatus: StatusTeapot,
+		},
+		{
+			name: "short-circuit after resolving",
+			middlewares: []testMiddleware{
+				{id: "before"},
+				{id: "respond", afterResolving: true, result: resultRespond},
+				{id: "skipped", priority: 1, afterResolving: true},
+			},
+			wantRan:    "before,respond",
+			wantStatus: StatusTeapot,
+		},
+		{
+			name: "abort before resolving",
+			middlewares: []testMiddleware{
+				{id: "fail", result: resultFail},
+				{id: "skipped", priority: 1},
+			},
+			wantRan: "fail",
+			wantErr: errMiddleware,
+		},
+		{
+			name: "abort after resolving",
+			middlewares: []testMiddleware{
+				{id: "before"},
+				{id: "fail", afterResolving: true, result: resultFail},
+			},
+			wantRan: "before,fail",
+			wantErr: errMiddleware,
+		},
+		{
+			name: "deployment scope",
+			middlewares: []testMiddleware{
+				{id: "svc", scope: &MiddlewareScope{DeploymentIds: []string{"svc"}}},
+				{id: "other", scope: &MiddlewareScope{DeploymentIds: []string{"other"}}},
+			},
+			wantRan:      "svc",
+			wantStatus:   StatusOK,
+			wantUpstream: true,
+		},
+		{
+			name: "host pattern scope",
+			url:  "http://other-svc/",
+			middlewares: []testMiddleware{
+				{id: "svc", scope: &MiddlewareScope{HostPattern: "svc"}},
+				{id: "any-svc", scope: &MiddlewareScope{HostPattern: "*svc"}},
+				{id: "any-svc-after", afterResolving: true, scope: &MiddlewareScope{HostPattern: "*svc"}},
+			},
+			wantRan:      "any-svc,any-svc-after",
+			wantStatus:   StatusOK,
+			wantUpstream: true,
+		},
+		{
+			name: "out of scope short-circuit",
+			middlewares: []testMiddleware{
+				{id: "respond", result: resultRespond, scope: &MiddlewareScope{}},
+				{id: "runs", priority: 1},
+			},
+			wantRan:      "runs",
+			wantStatus:   StatusOK,
+			wantUpstream: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, requests := newMiddlewareTestClient(t)
+			recorder := &middlewareRecorder{}
+			for _, mid := range test.middlewares {
+				mid.register(t, c, recorder)
+			}
+
+			url := test.url
+			if url == "" {
+				url = "http://svc/"
+			}
+
+			resp, err := c.Get(url)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("got %v, want %v", err, test.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else {
+				_ = resp.Body.Close()
+				if resp.StatusCode != test.wantStatus {
+					t.Errorf("got status %d, want %d", resp.StatusCode, test.wantStatus)
+				}
+				if resp.Request == nil {
+					t.Error("response has no request")
+				}
+			}
+
+			if got := recorder.String(); got != test.wantRan {
+				t.Errorf("got middlewares %s, want %s", got, test.wantRan)
+			}
+			if upstream := atomic.LoadInt32(requests) > 0; upstream != test.wantUpstream {
+				t.Errorf("request sent upstream: %t, want %t", upstream, test.wantUpstream)
+			}
+		})
+	}
+}
+
+func TestChainMiddlewareReplaceKeepsScope(t *testing.T) {
+	c, _ := newMiddlewareTestClient(t)
+	recorder := &middlewareRecorder{}
+
+	testMiddleware{id: "mid", scope: &MiddlewareScope{DeploymentIds: []string{"other"}}}.register(t, c, recorder)
+	testMiddleware{id: "mid", priority: 1}.register(t, c, recorder)
+
+	resp, err := c.Get("http://svc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if got := recorder.String(); got != "" {
+		t.Errorf("got middlewares %s, want the replaced middleware to keep its scope", got)
+	}
+	if !c.UnregisterMiddleware("mid") || c.UnregisterMiddleware("mid") {
+		t.Error("middleware was not unregistered exactly once")
+	}
+}
+
+func TestRegisterInvalidMiddleware(t *testing.T) {
+	c := &Client{}
+
+	if err := c.RegisterChainMiddleware("", 0, func(string, *Request) (*Response, error) { return nil, nil },
+		false); !errors.Is(err, ErrInvalidMiddleware) {
+		t.Errorf("got %v for a middleware without id, want ErrInvalidMiddleware", err)
+	}
+	if err := c.RegisterChainMiddleware("mid", 0, nil, false); !errors.Is(err, ErrInvalidMiddleware) {
+		t.Errorf("got %v for a middleware without function, want ErrInvalidMiddleware", err)
+	}
+	if err := c.ScopeMiddleware("missing", nil); !errors.Is(err, ErrMiddlewareNotFound) {
+		t.Errorf("got %v scoping a missing middleware, want ErrMiddlewareNotFound", err)
+	}
+}
+
+func TestObserverMiddlewareGetsACopy(t *testing.T) {
+	c, _ := newMiddlewareTestClient(t)
+
+	release := make(chan struct{})
+	observed := make(chan string, 2)
+	observer := func(reqId string, req *Request) {
+		<-release
+		observed <- req.URL.String() + " " + req.Header.Get("X-Chain")
+	}
+
+	if err := c.RegisterMiddleware("before", observer, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RegisterMiddleware("after", observer, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.RegisterChainMiddleware("header", 1, func(reqId string, req *Request) (*Response, error) {
+		req.Header.Set("X-Chain", "set")
+		return nil, nil
+	}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := c.Get("http://svc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	close(release)
+
+	got := map[string]bool{<-observed: true, <-observed: true}
+	for _, want := range []string{"http://svc/ ", "http://" + resp.Request.URL.Host + "/ set"} {
+		if !got[want] {
+			t.Errorf("observers saw %v, want %q", got, want)
+		}
+	}
+}
+
+// TestObserverMiddlewareRace reads the request from observers while it is being sent, for the race detector to check.
+func TestObserverMiddlewareRace(t *testing.T) {
+	c, _ := newMiddlewareTestClient(t)
+
+	var wg sync.WaitGroup
+	observer := func(reqId string, req *Request) {
+		defer wg.Done()
+		_ = req.URL.String() + req.Host + req.Header.Get("X-Chain")
+	}
+	for _, afterResolving := range []bool{false, true} {
+		id := "before"
+		if afterResolving {
+			id = "after"
+		}
+		if err := c.RegisterMiddleware(id, observer, afterResolving); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		resp, err := c.Get("http://svc/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+	wg.Wait()
+}
+
+func TestScopeTargetFor(t *testing.T) {
+	tests := []struct {
+		name             string
+		logical          string
+		deploymentIdFunc DeploymentIDFunc
+		want             scopeTarget
+		wantErr          error
+	}{
+		{name: "host", logical: "svc", want: scopeTarget{host: "svc", deploymentId: "svc"}},
+		{name: "host and port", logical: "dep-svc:8080", want: scopeTarget{host: "dep-svc", deploymentId: "dep"}},
+		{name: "ip", logical: "10.0.0.1:80", want: scopeTarget{host: "10.0.0.1"}},
+		{name: "ipv6", logical: "[::1]:80", want: scopeTarget{host: "::1"}},
+		{
+			name:             "mapped host",
+			logical:          "svc:80",
+			deploymentIdFunc: DeploymentIDMap(map[string]string{"svc": "dep"}),
+			want:             scopeTarget{host: "svc", deploymentId: "dep"},
+		},
+		{
+			name:             "unmapped host",
+			logical:          "svc:80",
+			deploymentIdFunc: DeploymentIDMap(map[string]string{}),
+			wantErr:          ErrNoDeploymentID,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			deploymentIdFunc := test.deploymentIdFunc
+			if deploymentIdFunc == nil {
+				deploymentIdFunc = DefaultDeploymentIDFunc
+			}
+
+			got, err := scopeTargetFor(test.logical, deploymentIdFunc)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got %v, want %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMiddlewareScopeMatches(t *testing.T) {
+	target := scopeTarget{host: "svc", deploymentId: "dep"}
+
+	tests := []struct {
+		name  string
+		scope *MiddlewareScope
+		want  bool
+	}{
+		{name: "global", scope: nil, want: true},
+		{name: "empty", scope: &MiddlewareScope{}, want: false},
+		{name: "deployment", scope: &MiddlewareScope{DeploymentIds: []string{"other", "dep"}}, want: true},
+		{name: "other deployment", scope: &MiddlewareScope{DeploymentIds: []string{"other"}}, want: false},
+		{name: "host", scope: &MiddlewareScope{HostPattern: "svc"}, want: true},
+		{name: "host pattern", scope: &MiddlewareScope{HostPattern: "s*"}, want: true},
+		{name: "other host", scope: &MiddlewareScope{HostPattern: "other"}, want: false},
+		{
+			name:  "deployment or host",
+			scope: &MiddlewareScope{DeploymentIds: []string{"other"}, HostPattern: "svc"},
+			want:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.scope.matches(target); got != test.want {
+				t.Errorf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScopedMiddlewareUsesLogicalHost(t *testing.T) {
+	c, _ := newMiddlewareTestClient(t)
+	recorder := &middlewareRecorder{}
+	testMiddleware{id: "global"}.register(t, c, recorder)
+	testMiddleware{id: "svc", priority: 1, scope: &MiddlewareScope{HostPattern: "svc"}}.register(t, c, recorder)
+	testMiddleware{id: "svc-after", afterResolving: true, scope: &MiddlewareScope{HostPattern: "svc"}}.register(t, c,
+		recorder)
+
+	resp, err := c.Get("http://svc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	// the request to the endpoint with the logical host in its Host header is still a request to svc
+	req, err := originalHttp.NewRequest("GET", "http://"+resp.Request.URL.Host+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "svc"
+	if resp, err = c.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp, err = c.Get("http://other-svc/"); err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if got, want := recorder.String(), "global,svc,svc-after,global,svc,svc-after,global"; got != want {
+		t.Errorf("got middlewares %s, want %s", got, want)
+	}
+}
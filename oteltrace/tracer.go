@@ -0,0 +1,102 @@
+// Package oteltrace records the spans of an archimedes http.Client with OpenTelemetry.
+//
+//	client.Tracer = oteltrace.New(tracerProvider, nil)
+package oteltrace
+
+import (
+	"context"
+	"fmt"
+
+	archimedes "github.com/bruno-anjos/archimedesHTTPClient"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/bruno-anjos/archimedesHTTPClient"
+
+// Tracer is an archimedes http.Tracer that records spans with an OpenTelemetry TracerProvider.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+var _ archimedes.Tracer = (*Tracer)(nil)
+
+// New returns a Tracer that records spans with provider and propagates them upstream with propagator. If provider is
+// nil the global TracerProvider is used and if propagator is nil the W3C trace context (traceparent) headers are.
+func New(provider trace.TracerProvider, propagator propagation.TextMapPropagator) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+
+	return &Tracer{tracer: provider.Tracer(instrumentationName), propagator: propagator}
+}
+
+func (t *Tracer) Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context,
+	archimedes.Span) {
+	kind := trace.SpanKindInternal
+	if name == archimedes.SpanUpstream {
+		kind = trace.SpanKindClient
+	}
+
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind),
+		trace.WithAttributes(attributes(keysAndValues)...))
+	return ctx, &otelSpan{span: span}
+}
+
+func (t *Tracer) Inject(ctx context.Context, header archimedes.Header) {
+	t.propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s *otelSpan) SetAttributes(keysAndValues ...interface{}) {
+	s.span.SetAttributes(attributes(keysAndValues)...)
+}
+
+func (s *otelSpan) AddEvent(name string, keysAndValues ...interface{}) {
+	s.span.AddEvent(name, trace.WithAttributes(attributes(keysAndValues)...))
+}
+
+func (s *otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// attributes turns alternating keys and values into attributes. A key without a value is dropped.
+func attributes(keysAndValues []interface{}) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(keysAndValues)/2)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		key := attribute.Key(fmt.Sprint(keysAndValues[i]))
+
+		switch value := keysAndValues[i+1].(type) {
+		case string:
+			attrs = append(attrs, key.String(value))
+		case int:
+			attrs = append(attrs, key.Int(value))
+		case int64:
+			attrs = append(attrs, key.Int64(value))
+		case float64:
+			attrs = append(attrs, key.Float64(value))
+		case bool:
+			attrs = append(attrs, key.Bool(value))
+		case fmt.Stringer:
+			attrs = append(attrs, key.String(value.String()))
+		default:
+			attrs = append(attrs, key.String(fmt.Sprint(value)))
+		}
+	}
+
+	return attrs
+}
@@ -0,0 +1,41 @@
+package oteltrace
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestAttributes(t *testing.T) {
+	got := attributes([]interface{}{
+		"string", "value",
+		"int", 1,
+		"int64", int64(2),
+		"float", 1.5,
+		"bool", true,
+		"stringer", time.Second,
+		"other", errors.New("error"),
+		"dangling",
+	})
+
+	want := []attribute.KeyValue{
+		attribute.String("string", "value"),
+		attribute.Int("int", 1),
+		attribute.Int64("int64", 2),
+		attribute.Float64("float", 1.5),
+		attribute.Bool("bool", true),
+		attribute.String("stringer", "1s"),
+		attribute.String("other", "error"),
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d attributes, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got[i], want[i])
+		}
+	}
+}
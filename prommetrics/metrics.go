@@ -0,0 +1,110 @@
+// Package prommetrics exposes the measurements of an archimedes http.Client as Prometheus metrics.
+//
+//	metrics, err := prommetrics.New(prometheus.DefaultRegisterer)
+//	...
+//	client.Metrics = metrics
+package prommetrics
+
+import (
+	"strconv"
+	"time"
+
+	archimedes "github.com/bruno-anjos/archimedesHTTPClient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "archimedes_client"
+
+// Metrics is an archimedes http.Metrics that exposes the client measurements as Prometheus counters and histograms.
+type Metrics struct {
+	cacheLookups       *prometheus.CounterVec
+	resolutionDuration *prometheus.HistogramVec
+	serverSwitches     *prometheus.CounterVec
+	reresolutions      *prometheus.CounterVec
+	geofenceViolations *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+}
+
+var _ archimedes.Metrics = (*Metrics)(nil)
+
+// New creates the client metrics and registers them with registerer.
+func New(registerer prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		cacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cache_lookups_total",
+			Help:      "Requests by how their endpoint was found (hit, stale_hit, miss, pinned or bypassed).",
+		}, []string{"status"}),
+		resolutionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "resolution_duration_seconds",
+			Help:      "Duration of resolutions in archimedes by outcome.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"outcome"}),
+		serverSwitches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "archimedes_server_switches_total",
+			Help:      "Changes of the primary archimedes server by reason.",
+		}, []string{"reason"}),
+		reresolutions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reresolutions_total",
+			Help:      "Resolutions done again because the cached endpoint failed, by deployment.",
+		}, []string{"deployment"}),
+		geofenceViolations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "geofence_violations_total",
+			Help:      "Endpoints outside the geofence of their deployment or request, by deployment and action taken.",
+		}, []string{"deployment", "action"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of requests, resolution included, by deployment and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"deployment", "status"}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.cacheLookups,
+		m.resolutionDuration,
+		m.serverSwitches,
+		m.reresolutions,
+		m.geofenceViolations,
+		m.requestDuration,
+	}
+	for _, collector := range collectors {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+func (m *Metrics) CacheLookup(status archimedes.CacheStatus) {
+	m.cacheLookups.WithLabelValues(status.String()).Inc()
+}
+
+func (m *Metrics) Resolution(outcome archimedes.ResolutionOutcome, latency time.Duration) {
+	m.resolutionDuration.WithLabelValues(string(outcome)).Observe(latency.Seconds())
+}
+
+func (m *Metrics) ArchimedesServerSwitch(reason archimedes.ServerSwitchReason) {
+	m.serverSwitches.WithLabelValues(string(reason)).Inc()
+}
+
+func (m *Metrics) Reresolution(deploymentId string) {
+	m.reresolutions.WithLabelValues(deploymentId).Inc()
+}
+
+func (m *Metrics) GeofenceViolation(deploymentId string, action archimedes.GeofenceAction) {
+	m.geofenceViolations.WithLabelValues(deploymentId, string(action)).Inc()
+}
+
+func (m *Metrics) Request(deploymentId string, statusCode int, latency time.Duration) {
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	m.requestDuration.WithLabelValues(deploymentId, status).Observe(latency.Seconds())
+}
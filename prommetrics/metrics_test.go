@@ -0,0 +1,38 @@
+package prommetrics
+
+import (
+	"testing"
+	"time"
+
+	archimedes "github.com/bruno-anjos/archimedesHTTPClient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m, err := New(registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.CacheLookup(archimedes.CacheHit)
+	m.CacheLookup(archimedes.CacheHit)
+	m.Reresolution("svc")
+	m.Request("svc", 0, time.Second)
+	m.Request("svc", archimedes.StatusOK, time.Second)
+
+	if got := testutil.ToFloat64(m.cacheLookups.WithLabelValues("hit")); got != 2 {
+		t.Errorf("got %v hits, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.reresolutions.WithLabelValues("svc")); got != 1 {
+		t.Errorf("got %v reresolutions, want 1", got)
+	}
+	if got := testutil.CollectAndCount(m.requestDuration); got != 2 {
+		t.Errorf("got %d request series, want 2 (error and 200)", got)
+	}
+
+	if _, err := New(registry); err == nil {
+		t.Error("registering the metrics twice succeeded")
+	}
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+)
+
+// maxRedirects is the number of redirects followed when the client has no CheckRedirect, the same as net/http.
+const maxRedirects = 10
+
+var ErrRedirectLoop = errors.New("redirect loop")
+
+// checkRedirect returns the redirect policy for a single Do call. Every redirect is resolved through archimedes like
+// the original request, since its Location may name a different logical service, and redirects pointing back at an
+// endpoint that was resolved before are mapped back to its logical host.
+//
+// Without a CheckRedirect of the client, at most maxRedirects redirects are followed, like in net/http, and a redirect
+// that was already followed by the same Do call is a loop. Flows that only come back to a URL, like /a to /b and back
+// to /a when /a answers the second time, still work. Otherwise, the client's CheckRedirect is called with the
+// rewritten request and is the only limit.
+func (c *Client) checkRedirect(hops *requestHops, userCheck func(req *Request, via []*Request) error) func(
+	req *Request, via []*Request) error {
+	return func(req *Request, via []*Request) error {
+		logical := req.URL.Host
+		if mapped, ok := hops.logicalFor(req.URL.Host); ok {
+			logical = mapped
+		}
+
+		logicalUrl := *req.URL
+		logicalUrl.Host = logical
+
+		if userCheck == nil {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if from, ok := repeatedRedirect(hops, req, via); ok {
+				return fmt.Errorf("%w: %s redirected to %s again", ErrRedirectLoop, from, logicalUrl.String())
+			}
+		}
+
+		hostPort, err := c.withDefaultPort(req.URL.Scheme, logical)
+		if err != nil {
+			return err
+		}
+
+		deploymentId, err := deploymentIdFor(hops.opts.deploymentIdFunc, hostWithoutPort(hostPort))
+		if err != nil {
+			return err
+		}
+
+		reqId, _ := RequestIDFromContext(req.Context())
+		archimedesTrace := c.archimedesTrace(req.Context())
+		archimedesTrace.resolveStart(ResolveStartInfo{Host: hostPort})
+
+		resolvedHostPort := hostPort
+		found, cached := true, false
+		if !isLiteralHostPort(hostPort) {
+			value, ok := c.cache.Load(c.cacheKey(hostPort, hops.opts.location))
+			if ok && hops.opts.cacheable() {
+				entry := value.(addressCacheValue)
+				resolvedHostPort = entry.getResolved()
+				cached = true
+				archimedesTrace.cacheHit(CacheHitInfo{Host: hostPort, Endpoint: resolvedHostPort,
+					Stale: entry.isStale()})
+			} else {
+				resolvedHostPort, found, err = c.resolveServiceInArchimedes(req.Context(), hostPort, hops.opts)
+				if err != nil {
+					archimedesTrace.resolveDone(ResolveDoneInfo{Host: hostPort, Status: ResolutionError, Err: err})
+					return err
+				}
+
+				if !found {
+					c.logger().Debug("could not resolve redirect", LogFieldReqId, reqId,
+						LogFieldHost, hostPort)
+				}
+			}
+		}
+
+		if geofences := c.geofencesFor(req.Context(), deploymentId); len(geofences) > 0 {
+			allowedHostPort, err := c.enforceGeofences(req.Context(), geofences, deploymentId, hostPort,
+				resolvedHostPort, hops.opts)
+			if err != nil {
+				archimedesTrace.resolveDone(ResolveDoneInfo{Host: hostPort, Endpoint: resolvedHostPort,
+					Status: ResolutionError, Err: err})
+				return err
+			}
+
+			if allowedHostPort != resolvedHostPort {
+				resolvedHostPort, cached = allowedHostPort, false
+			}
+		}
+
+		archimedesTrace.resolveDone(ResolveDoneInfo{Host: hostPort, Endpoint: resolvedHostPort,
+			Status: resolutionOutcome(found, nil), Cached: cached})
+		c.logger().Debug("resolved redirect", LogFieldReqId, reqId, "url",
+			logicalUrl.String(), LogFieldEndpoint, resolvedHostPort)
+
+		hops.add(resolvedHostPort, logical, deploymentId)
+		req.URL.Host = resolvedHostPort
+		c.applyHostPolicy(req, logical, resolvedHostPort)
+
+		if userCheck != nil {
+			return userCheck(req, via)
+		}
+		return nil
+	}
+}
+
+// repeatedRedirect tells whether the redirect from the last request in via to req was already followed, returning the
+// logical URL it comes from. Requests are compared by method and logical URL, so that the endpoints of a service
+// changing between hops does not hide a loop.
+func repeatedRedirect(hops *requestHops, req *Request, via []*Request) (from string, ok bool) {
+	redirect := func(from, to *Request) string {
+		return from.Method + " " + hops.logicalURL(from.URL).String() + " -> " + to.Method + " " +
+			hops.logicalURL(to.URL).String()
+	}
+
+	last := redirect(via[len(via)-1], req)
+	for i := 1; i < len(via); i++ {
+		if redirect(via[i-1], via[i]) == last {
+			return hops.logicalURL(via[len(via)-1].URL).String(), true
+		}
+	}
+	return "", false
+}
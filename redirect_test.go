@@ -0,0 +1,146 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	originalHttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newRedirectTestServer returns a server whose /a redirects to /b the first time and answers after, /b redirects to
+// /a, /loop redirects to itself and /chain/n redirects to /chain/n-1 until /chain/0, counting the requests it gets.
+func newRedirectTestServer(t *testing.T) (*httptest.Server, *int32) {
+	var requests, aRequests int32
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {
+		atomic.AddInt32(&requests, 1)
+
+		switch r.URL.Path {
+		case "/a":
+			if atomic.AddInt32(&aRequests, 1) == 1 {
+				originalHttp.Redirect(w, r, "/b", StatusFound)
+				return
+			}
+			w.WriteHeader(StatusOK)
+		case "/b":
+			originalHttp.Redirect(w, r, "/a", StatusFound)
+		case "/loop":
+			originalHttp.Redirect(w, r, "/loop", StatusFound)
+		case "/chain/0":
+			w.WriteHeader(StatusOK)
+		default:
+			if n, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/chain/")); err == nil && n > 0 {
+				originalHttp.Redirect(w, r, fmt.Sprintf("/chain/%d", n-1), StatusFound)
+				return
+			}
+			w.WriteHeader(StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &requests
+}
+
+func TestRedirectBackToVisitedURL(t *testing.T) {
+	server, _ := newRedirectTestServer(t)
+	c := &Client{initialized: true}
+
+	resp, err := c.Get(server.URL + "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != StatusOK {
+		t.Errorf("got status %d, want %d", resp.StatusCode, StatusOK)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		checkRedirect func(req *Request, via []*Request) error
+		wantErr       string
+		wantRequests  int32
+	}{
+		{name: "chain", path: "/chain/6", wantRequests: 7},
+		{name: "longest chain", path: "/chain/9", wantRequests: 10},
+		{name: "too many redirects", path: "/chain/10", wantErr: "stopped after 10 redirects", wantRequests: 10},
+		{name: "loop", path: "/loop", wantErr: ErrRedirectLoop.Error(), wantRequests: 2},
+		{
+			name: "client policy",
+			path: "/loop",
+			checkRedirect: func(req *Request, via []*Request) error {
+				if len(via) >= 3 {
+					return errors.New("stopped by the client")
+				}
+				return nil
+			},
+			wantErr:      "stopped by the client",
+			wantRequests: 3,
+		},
+		{
+			name:          "client policy longer than the default",
+			path:          "/chain/11",
+			checkRedirect: func(req *Request, via []*Request) error { return nil },
+			wantRequests:  12,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, requests := newRedirectTestServer(t)
+			c := &Client{initialized: true}
+			c.CheckRedirect = test.checkRedirect
+
+			resp, err := c.Get(server.URL + test.path)
+			switch {
+			case test.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got %v, want %s", err, test.wantErr)
+				}
+			case err != nil:
+				t.Fatal(err)
+			default:
+				_ = resp.Body.Close()
+				if resp.StatusCode != StatusOK {
+					t.Errorf("got status %d, want %d", resp.StatusCode, StatusOK)
+				}
+			}
+
+			if got := atomic.LoadInt32(requests); got != test.wantRequests {
+				t.Errorf("got %d requests, want %d", got, test.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRedirectLoopWithCachedEndpoint(t *testing.T) {
+	server, _ := newRedirectTestServer(t)
+	c := &Client{initialized: true}
+	c.cache.Store(c.ownCacheKey("svc:80"), newCacheEntry(server.Listener.Addr().String(), "svc", "tcp"))
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get("http://svc/loop"); !errors.Is(err, ErrRedirectLoop) {
+			t.Fatalf("request %d: got %v, want ErrRedirectLoop", i, err)
+		}
+	}
+
+	resp, err := c.Get("http://svc/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != StatusOK {
+		t.Errorf("got status %d, want %d", resp.StatusCode, StatusOK)
+	}
+	if resp.Request.URL.Host != server.Listener.Addr().String() {
+		t.Errorf("last hop sent to %s, want the cached endpoint %s", resp.Request.URL.Host,
+			server.Listener.Addr().String())
+	}
+}
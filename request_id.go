@@ -0,0 +1,65 @@
+package http
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRequestIDHeader is the header the request ID is sent in when the client has no RequestIDHeader.
+const DefaultRequestIDHeader = "X-Request-Id"
+
+type requestIDContextKey struct{}
+
+// WithRequestID returns a copy of ctx that makes requests done with it use reqId as their request ID instead of a new
+// one. Servers can use it to keep the ID of the request they are handling in the requests they do because of it.
+func WithRequestID(ctx context.Context, reqId string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, reqId)
+}
+
+// RequestIDFromContext returns the request ID in ctx, if there is one. The contexts of the requests done with
+// Client.Do and the ones passed to their middlewares always have one.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	reqId, ok := ctx.Value(requestIDContextKey{}).(string)
+	return reqId, ok && reqId != ""
+}
+
+func (c *Client) requestIDHeader() string {
+	if c.RequestIDHeader != "" {
+		return c.RequestIDHeader
+	}
+	return DefaultRequestIDHeader
+}
+
+// withRequestID returns a shallow copy of req that carries its request ID, both in its context and in the request ID
+// header. The ID is taken from the context, then from the header, and a new one is generated if neither has it.
+func (c *Client) withRequestID(req *Request) (*Request, string) {
+	header := c.requestIDHeader()
+
+	reqId, ok := RequestIDFromContext(req.Context())
+	if !ok {
+		reqId = req.Header.Get(header)
+	}
+	if reqId == "" {
+		reqId = uuid.New().String()
+	}
+
+	req = req.WithContext(WithRequestID(req.Context(), reqId))
+	if req.Header.Get(header) != reqId {
+		req.Header = req.Header.Clone()
+		if req.Header == nil {
+			req.Header = Header{}
+		}
+		req.Header.Set(header, reqId)
+	}
+
+	return req, reqId
+}
+
+// requestIDFor returns the request ID in ctx or, for resolutions that do not belong to any request, a new one.
+func requestIDFor(ctx context.Context) string {
+	if reqId, ok := RequestIDFromContext(ctx); ok {
+		return reqId
+	}
+	return uuid.New().String()
+}
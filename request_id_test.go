@@ -0,0 +1,174 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	originalHttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// logRecorder is a Logger that keeps the request IDs its entries were logged with.
+type logRecorder struct {
+	reqIds []string
+	sync.Mutex
+}
+
+func (l *logRecorder) log(keysAndValues []interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		if keysAndValues[i] == LogFieldReqId {
+			l.reqIds = append(l.reqIds, fmt.Sprint(keysAndValues[i+1]))
+		}
+	}
+}
+
+func (l *logRecorder) Debug(_ string, keysAndValues ...interface{}) { l.log(keysAndValues) }
+func (l *logRecorder) Info(_ string, keysAndValues ...interface{})  { l.log(keysAndValues) }
+func (l *logRecorder) Warn(_ string, keysAndValues ...interface{})  { l.log(keysAndValues) }
+func (l *logRecorder) Error(_ string, keysAndValues ...interface{}) { l.log(keysAndValues) }
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    string
+		ctxId     string
+		headerId  string
+		want      string
+		generated bool
+	}{
+		{name: "new", generated: true},
+		{name: "context", ctxId: "ctx-id", want: "ctx-id"},
+		{name: "header", headerId: "header-id", want: "header-id"},
+		{name: "context over header", ctxId: "ctx-id", headerId: "header-id", want: "ctx-id"},
+		{name: "client header", header: "X-Trace", headerId: "header-id", want: "header-id"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Client{RequestIDHeader: test.header}
+			ctx := context.Background()
+			if test.ctxId != "" {
+				ctx = WithRequestID(ctx, test.ctxId)
+			}
+			req, err := NewRequestWithContext(ctx, "GET", "http://svc/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.headerId != "" {
+				req.Header.Set(c.requestIDHeader(), test.headerId)
+			}
+
+			withId, reqId := c.withRequestID(req)
+
+			if test.generated {
+				if _, err := uuid.Parse(reqId); err != nil {
+					t.Errorf("got generated ID %q: %v", reqId, err)
+				}
+			} else if reqId != test.want {
+				t.Errorf("got ID %q, want %q", reqId, test.want)
+			}
+
+			if got, _ := RequestIDFromContext(withId.Context()); got != reqId {
+				t.Errorf("got ID %q in the context, want %q", got, reqId)
+			}
+			if got := withId.Header.Get(c.requestIDHeader()); got != reqId {
+				t.Errorf("got ID %q in the header, want %q", got, reqId)
+			}
+			if got := req.Header.Get(c.requestIDHeader()); got != test.headerId {
+				t.Errorf("changed the header of the caller's request to %q", got)
+			}
+		})
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	var serverId string
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {
+		serverId = r.Header.Get(DefaultRequestIDHeader)
+	}))
+	defer server.Close()
+
+	archimedes := &fakeArchimedes{endpoints: map[string]string{"svc": server.Listener.Addr().String()}}
+	c := newFakeArchimedesClient(archimedes)
+	logs := &logRecorder{}
+	c.Logger = logs
+
+	// every middleware records the ID it is passed and the ones in the context and header of the request
+	var middlewareIds []string
+	record := func(reqId string, req *Request) (*Response, error) {
+		ctxId, _ := RequestIDFromContext(req.Context())
+		middlewareIds = append(middlewareIds, reqId, ctxId, req.Header.Get(DefaultRequestIDHeader))
+		return nil, nil
+	}
+	for _, afterResolving := range []bool{false, true} {
+		if err := c.RegisterChainMiddleware(fmt.Sprint(afterResolving), 0, record, afterResolving); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, reqId := range []string{"", "caller-id"} {
+		t.Run(fmt.Sprintf("id %q", reqId), func(t *testing.T) {
+			middlewareIds, serverId = nil, ""
+			logs.Lock()
+			logs.reqIds = nil
+			logs.Unlock()
+			archimedes.Lock()
+			archimedes.resolutions = nil
+			archimedes.Unlock()
+			c.cache = sync.Map{}
+
+			ctx := context.Background()
+			if reqId != "" {
+				ctx = WithRequestID(ctx, reqId)
+			}
+			req, err := NewRequestWithContext(ctx, "GET", "http://svc/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			want := serverId
+			if reqId != "" && want != reqId {
+				t.Errorf("server got ID %q, want %q", want, reqId)
+			}
+			if _, err := uuid.Parse(want); reqId == "" && err != nil {
+				t.Errorf("server got ID %q, want a new one: %v", want, err)
+			}
+
+			if len(middlewareIds) != 6 {
+				t.Fatalf("got %d IDs from middlewares, want 6", len(middlewareIds))
+			}
+			for _, got := range middlewareIds {
+				if got != want {
+					t.Errorf("middleware got ID %q, want %q", got, want)
+				}
+			}
+
+			resolutions := archimedes.resolved()
+			if len(resolutions) != 1 || resolutions[0].reqId != want {
+				t.Errorf("archimedes got resolutions %+v, want one with ID %q", resolutions, want)
+			}
+
+			logs.Lock()
+			defer logs.Unlock()
+			if len(logs.reqIds) == 0 {
+				t.Error("logged nothing with the request ID")
+			}
+			for _, got := range logs.reqIds {
+				if got != want {
+					t.Errorf("logged ID %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
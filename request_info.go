@@ -0,0 +1,93 @@
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// CacheStatus tells how the endpoint a request was sent to was found.
+type CacheStatus int
+
+const (
+	// CacheMiss means the service was not cached and was resolved in archimedes.
+	CacheMiss CacheStatus = iota
+	// CacheHit means the service was resolved using a fresh cache entry.
+	CacheHit
+	// CacheStaleHit means the service was resolved using a cache entry that had already expired but was not yet
+	// removed from the cache.
+	CacheStaleHit
+	// CachePinned means the request belongs to a session pinned to an endpoint.
+	CachePinned
+	// CacheBypassed means the request did not need resolving, e.g. because it was addressed to an IP.
+	CacheBypassed
+)
+
+func (s CacheStatus) String() string {
+	switch s {
+	case CacheMiss:
+		return "miss"
+	case CacheHit:
+		return "hit"
+	case CacheStaleHit:
+		return "stale_hit"
+	case CachePinned:
+		return "pinned"
+	case CacheBypassed:
+		return "bypassed"
+	default:
+		return "unknown"
+	}
+}
+
+// RequestInfo describes how a request done with Client.Do went, from resolution to response.
+type RequestInfo struct {
+	ReqId        string
+	DeploymentId string
+
+	// OriginalURL is the URL the request was addressed to and ResolvedURL the one it was sent to. ResolvedURL is nil
+	// if the request did not get to be resolved.
+	OriginalURL *url.URL
+	ResolvedURL *url.URL
+
+	CacheStatus CacheStatus
+
+	// ResolutionLatency is the time spent finding the endpoint the request was sent to, including re-resolutions
+	// after failing to use a cached endpoint. UpstreamLatency is the time spent sending the request and waiting for
+	// its response.
+	ResolutionLatency time.Duration
+	UpstreamLatency   time.Duration
+
+	// StatusCode is the status code of the response, or 0 if there was none.
+	StatusCode int
+	Err        error
+}
+
+// ResponseMiddlewareFunc is a middleware that runs synchronously, in priority order, once a request done with
+// Client.Do has finished, whether it succeeded or not. resp is nil if the request failed.
+type ResponseMiddlewareFunc = func(info *RequestInfo, resp *Response)
+
+// RegisterResponseMiddleware registers a middleware with id midId and a function midFunc that is ran everytime a
+// request finishes, after every response middleware with a lower priority. It receives the response, if any, and how
+// the request went, so that it can be used to track latencies and outcomes per deployment. If a response middleware
+// with the same id was already registered it is replaced.
+func (c *Client) RegisterResponseMiddleware(midId string, priority int, midFunc ResponseMiddlewareFunc) error {
+	if midFunc == nil {
+		return fmt.Errorf("%w: middleware %s has no function", ErrInvalidMiddleware, midId)
+	}
+
+	return c.registerMiddleware(&middleware{id: midId, priority: priority, response: midFunc},
+		&c.responseMiddlewares)
+}
+
+func (c *Client) runResponseMiddlewares(info *RequestInfo, resp *Response) {
+	target := scopeTarget{host: info.OriginalURL.Hostname(), deploymentId: info.DeploymentId}
+	for _, mid := range c.responseMiddlewares.snapshot() {
+		if !mid.scope.matches(target) {
+			continue
+		}
+
+		c.logger().Debug("calling response middleware", LogFieldReqId, info.ReqId, "middleware", mid.id)
+		mid.response(info, resp)
+	}
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+var ErrResolutionTimeout = errors.New("resolution timed out")
+
+type (
+	noCacheContextKey           struct{}
+	endpointContextKey          struct{}
+	resolutionTimeoutContextKey struct{}
+)
+
+// WithNoCache returns a copy of ctx that makes requests done with it skip the cache and session pins, always resolving
+// the service in archimedes. The resolution is not cached either, so the requests have no effect on others.
+func WithNoCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheContextKey{}, true)
+}
+
+// WithEndpoint returns a copy of ctx that makes requests done with it go to endpoint, a host:port, without resolving
+// the service at all, e.g. to try a specific instance, so their hosts need not belong to any deployment. Redirects
+// are still resolved.
+func WithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointContextKey{}, endpoint)
+}
+
+// WithLocation returns a copy of ctx that makes requests done with it resolve services as if the client was at
+// location, e.g. when proxying on behalf of users elsewhere. Their resolutions are cached in the cell of location.
+func WithLocation(ctx context.Context, location s2.LatLng) context.Context {
+	return withCell(ctx, s2.CellIDFromLatLng(location))
+}
+
+// WithResolutionTimeout returns a copy of ctx that makes requests done with it give up resolving a service in
+// archimedes after timeout, failing with ErrResolutionTimeout. Without it, a resolution is retried for as long as
+// archimedes times out.
+func WithResolutionTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, resolutionTimeoutContextKey{}, timeout)
+}
+
+func endpointFromContext(ctx context.Context) (string, bool) {
+	endpoint, ok := ctx.Value(endpointContextKey{}).(string)
+	return endpoint, ok && endpoint != ""
+}
+
+func noCacheFromContext(ctx context.Context) bool {
+	noCache, _ := ctx.Value(noCacheContextKey{}).(bool)
+	return noCache
+}
+
+func resolutionTimeoutFromContext(ctx context.Context) time.Duration {
+	timeout, _ := ctx.Value(resolutionTimeoutContextKey{}).(time.Duration)
+	return timeout
+}
+
+// resolutionContextError is the error of a resolution that ctx stopped, either because it was canceled or because
+// its deadline, possibly the resolution timeout, passed.
+func resolutionContextError(ctx context.Context, hostPort string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: resolving %s: %w", ErrResolutionTimeout, hostPort, ctx.Err())
+	}
+	return fmt.Errorf("resolving %s: %w", hostPort, ctx.Err())
+}
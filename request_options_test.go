@@ -0,0 +1,141 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	originalHttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newEndpoints starts n servers that answer with their index and returns their host:port.
+func newEndpoints(t *testing.T, n int) []string {
+	t.Helper()
+	endpoints := make([]string, n)
+	for i := range endpoints {
+		body := string(rune('0' + i))
+		server := httptest.NewServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {
+			_, _ = w.Write([]byte(body))
+		}))
+		t.Cleanup(server.Close)
+		endpoints[i] = server.Listener.Addr().String()
+	}
+	return endpoints
+}
+
+// getBody does a GET to url with ctx and returns the body of the response.
+func getBody(ctx context.Context, c *Client, url string) (string, error) {
+	req, err := originalHttp.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestWithEndpoint(t *testing.T) {
+	endpoints := newEndpoints(t, 2)
+	archimedes := &fakeArchimedes{endpoints: map[string]string{"svc": endpoints[0]}}
+	c := newFakeArchimedesClient(archimedes)
+	c.DeploymentIDFunc = DeploymentIDMap(map[string]string{"svc": "svc"})
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{name: "mapped host", url: "http://svc/"},
+		{name: "unmapped host", url: "http://unmapped/"},
+		{name: "unmapped host with port", url: "http://unmapped:8080/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, err := getBody(WithEndpoint(context.Background(), endpoints[1]), c, test.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body != "1" {
+				t.Errorf("got response from endpoint %s, want 1", body)
+			}
+		})
+	}
+
+	if resolved := archimedes.resolved(); len(resolved) != 0 {
+		t.Errorf("resolved %+v, want the endpoint used as is", resolved)
+	}
+
+	if _, err := getBody(context.Background(), c, "http://unmapped/"); !errors.Is(err, ErrNoDeploymentID) {
+		t.Errorf("got %v without an endpoint, want %v", err, ErrNoDeploymentID)
+	}
+}
+
+func TestWithNoCache(t *testing.T) {
+	endpoints := newEndpoints(t, 2)
+	archimedes := &fakeArchimedes{endpoints: map[string]string{"svc": endpoints[0]}}
+	c := newFakeArchimedesClient(archimedes)
+
+	get := func(ctx context.Context, want string) {
+		t.Helper()
+		body, err := getBody(ctx, c, "http://svc/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != want {
+			t.Errorf("got response from endpoint %s, want %s", body, want)
+		}
+	}
+
+	get(context.Background(), "0")
+
+	// the service moves, which the cache does not know about yet
+	archimedes.Lock()
+	archimedes.endpoints["svc"] = endpoints[1]
+	archimedes.Unlock()
+
+	get(WithNoCache(context.Background()), "1")
+	get(WithNoCache(context.Background()), "1")
+	get(context.Background(), "0")
+
+	if got := len(archimedes.resolved()); got != 3 {
+		t.Errorf("resolved svc %d times, want 3", got)
+	}
+}
+
+func TestWithResolutionTimeout(t *testing.T) {
+	endpoints := newEndpoints(t, 1)
+	archimedes := &fakeArchimedes{endpoints: map[string]string{"svc": endpoints[0]}, timeOut: true}
+	c := newFakeArchimedesClient(archimedes)
+
+	start := time.Now()
+	_, err := getBody(WithResolutionTimeout(context.Background(), 50*time.Millisecond), c, "http://svc/")
+	if !errors.Is(err, ErrResolutionTimeout) {
+		t.Fatalf("got %v, want %v", err, ErrResolutionTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %s, want about 50ms", elapsed)
+	}
+	if _, ok := c.cache.Load(c.ownCacheKey("svc:80")); ok {
+		t.Error("cached a resolution that timed out")
+	}
+
+	archimedes.Lock()
+	archimedes.timeOut = false
+	archimedes.Unlock()
+
+	body, err := getBody(WithResolutionTimeout(context.Background(), time.Second), c, "http://svc/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "0" {
+		t.Errorf("got response from endpoint %s, want 0", body)
+	}
+}
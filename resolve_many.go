@@ -0,0 +1,87 @@
+package http
+
+import (
+	"context"
+	"sync"
+)
+
+// maxConcurrentResolutions is the maximum number of resolutions ResolveMany has in flight at once.
+const maxConcurrentResolutions = 16
+
+// Resolution is the result of resolving a single host with ResolveMany.
+type Resolution struct {
+	Host     string
+	Resolved string
+	Found    bool
+	Cached   bool
+	Err      error
+}
+
+// ResolveMany resolves the given hosts concurrently, filling the cache with the results, so that services that depend
+// on many deployments can warm all of them at startup. As in Watch, hosts can be given as a host:port, a URL or a bare
+// host, which uses the default http port, and hosts with the same host:port (e.g. svc and svc:80) are resolved once.
+// Hosts that are already cached are not resolved again. Archimedes has no batch resolution endpoint, so each host is
+// resolved with its own request.
+//
+// The returned resolutions are in the same order as hosts. Hosts that were not resolved before ctx was done have
+// its error as Err.
+func (c *Client) ResolveMany(ctx context.Context, hosts []string) []Resolution {
+	if !c.initialized {
+		panic("client has not been initialized")
+	}
+
+	opts := c.resolveOptionsFor(ctx, "")
+	resolutions := make([]Resolution, len(hosts))
+	semaphore := make(chan struct{}, maxConcurrentResolutions)
+	wg := &sync.WaitGroup{}
+
+	// first is the index of the first of the hosts with each host:port, whose resolution the others share
+	first := make(map[string]int, len(hosts))
+	hostPorts := make([]string, len(hosts))
+
+	for i, host := range hosts {
+		resolutions[i].Host = host
+		hostPort := c.serviceHostPort(host)
+		hostPorts[i] = hostPort
+
+		if _, ok := first[hostPort]; ok {
+			continue
+		}
+		first[hostPort] = i
+
+		if value, ok := c.cache.Load(c.cacheKey(hostPort, opts.location)); ok && opts.cacheable() {
+			resolutions[i].Resolved = value.(addressCacheValue).getResolved()
+			resolutions[i].Found = true
+			resolutions[i].Cached = true
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			resolutions[i].Err = ctx.Err()
+			continue
+		case semaphore <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(resolution *Resolution, hostPort string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			resolution.Resolved, resolution.Found, resolution.Err = c.resolveServiceInArchimedes(ctx, hostPort,
+				opts)
+		}(&resolutions[i], hostPort)
+	}
+
+	wg.Wait()
+
+	for i, hostPort := range hostPorts {
+		if j := first[hostPort]; j != i {
+			resolutions[i] = resolutions[j]
+			resolutions[i].Host = hosts[i]
+		}
+	}
+	c.logger().Debug("resolved hosts", "count", len(hosts))
+
+	return resolutions
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolveMany(t *testing.T) {
+	archimedes := &fakeArchimedes{endpoints: map[string]string{
+		"svc":       "10.0.0.1:8080",
+		"other-svc": "10.0.0.2:8080",
+	}}
+	c := newFakeArchimedesClient(archimedes)
+
+	hosts := []string{"svc:80", "other-svc:8080", "missing:80", "10.0.0.3:80", "svc", "http://svc", "https://svc"}
+	want := []Resolution{
+		{Host: "svc:80", Resolved: "10.0.0.1:8080", Found: true},
+		{Host: "other-svc:8080", Resolved: "10.0.0.2:8080", Found: true},
+		{Host: "missing:80", Resolved: "missing:80"},
+		{Host: "10.0.0.3:80", Resolved: "10.0.0.3:80", Found: true},
+		{Host: "svc", Resolved: "10.0.0.1:8080", Found: true},
+		{Host: "http://svc", Resolved: "10.0.0.1:8080", Found: true},
+		{Host: "https://svc", Resolved: "10.0.0.1:8080", Found: true},
+	}
+
+	got := c.ResolveMany(context.Background(), hosts)
+	if len(got) != len(want) {
+		t.Fatalf("got %d resolutions, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %+v, want %+v", got[i], want[i])
+		}
+	}
+
+	// svc, svc:80 and http://svc are the same service, https://svc is svc:443
+	resolved := map[string]int{}
+	for _, resolution := range archimedes.resolved() {
+		resolved[resolution.host+":"+resolution.port.Port()]++
+	}
+	if resolved["svc:80"] != 1 || resolved["svc:443"] != 1 {
+		t.Errorf("got resolutions %v, want svc:80 and svc:443 resolved once", resolved)
+	}
+
+	// the hosts that were found are now cached
+	resolutions := len(archimedes.resolved())
+	cached := []Resolution{
+		{Host: "svc", Resolved: "10.0.0.1:8080", Found: true, Cached: true},
+		{Host: "other-svc:8080", Resolved: "10.0.0.2:8080", Found: true, Cached: true},
+	}
+	for i, resolution := range c.ResolveMany(context.Background(), []string{"svc", "other-svc:8080"}) {
+		if resolution != cached[i] {
+			t.Errorf("got %+v, want %+v", resolution, cached[i])
+		}
+	}
+	if got := len(archimedes.resolved()); got != resolutions {
+		t.Errorf("resolved %d hosts again, want them cached", got-resolutions)
+	}
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	originalHttp "net/http"
+	"net/url"
+	"sync"
+)
+
+// requestHops keeps track of the logical hosts the requests sent by a single Do call (the original request and the
+// redirects it follows) were addressed to, before being rewritten to the endpoints archimedes resolved.
+type requestHops struct {
+	opts *resolveOptions
+	hops map[string]requestHop
+	sync.Mutex
+}
+
+// requestHop is the logical host a resolved endpoint was addressed as and the deployment it belongs to.
+type requestHop struct {
+	logical      string
+	deploymentId string
+}
+
+func newRequestHops(opts *resolveOptions) *requestHops {
+	return &requestHops{
+		opts:  opts,
+		hops:  map[string]requestHop{},
+		Mutex: sync.Mutex{},
+	}
+}
+
+// add records that logical, a host of deploymentId, was rewritten to resolvedHostPort.
+func (h *requestHops) add(resolvedHostPort, logical, deploymentId string) {
+	h.Lock()
+	defer h.Unlock()
+	h.hops[resolvedHostPort] = requestHop{logical: logical, deploymentId: deploymentId}
+}
+
+// hopFor returns the hop that was rewritten to resolvedHostPort, if any.
+func (h *requestHops) hopFor(resolvedHostPort string) (hop requestHop, ok bool) {
+	h.Lock()
+	defer h.Unlock()
+	hop, ok = h.hops[resolvedHostPort]
+	return hop, ok
+}
+
+// logicalFor returns the logical host that was rewritten to resolvedHostPort, if any.
+func (h *requestHops) logicalFor(resolvedHostPort string) (logical string, ok bool) {
+	hop, ok := h.hopFor(resolvedHostPort)
+	return hop.logical, ok
+}
+
+// logicalURL returns a copy of u whose host is the logical host it was rewritten from, if any.
+func (h *requestHops) logicalURL(u *url.URL) *url.URL {
+	logical, ok := h.logicalFor(u.Host)
+	if !ok {
+		return u
+	}
+
+	logicalUrl := *u
+	logicalUrl.Host = logical
+	return &logicalUrl
+}
+
+// archimedesTransport wraps the client's transport for a single Do call, so that requests rewritten to resolved
+// endpoints are sent with the TLS settings of their logical host.
+type archimedesTransport struct {
+	client *Client
+	base   RoundTripper
+	hops   *requestHops
+}
+
+func (t *archimedesTransport) RoundTrip(req *Request) (*Response, error) {
+	if req.URL.Scheme != "https" {
+		return t.base.RoundTrip(req)
+	}
+
+	hop, ok := t.hops.hopFor(req.URL.Host)
+	if !ok || hop.logical == req.URL.Host {
+		return t.base.RoundTrip(req)
+	}
+
+	return t.client.tlsTransportFor(hostWithoutPort(hop.logical), hop.deploymentId, t.base).RoundTrip(req)
+}
+
+// httpClientFor returns a copy of the embedded http client whose transport, redirect policy and cookie jar resolve
+// and rewrite every hop of a single Do call.
+func (c *Client) httpClientFor(hops *requestHops) *originalHttp.Client {
+	httpClient := c.Client
+
+	base := httpClient.Transport
+	if base == nil {
+		base = DefaultTransport
+	}
+
+	httpClient.Transport = &archimedesTransport{client: c, base: base, hops: hops}
+	httpClient.CheckRedirect = c.checkRedirect(hops, c.Client.CheckRedirect)
+	if httpClient.Jar != nil {
+		httpClient.Jar = &logicalJar{base: httpClient.Jar, hops: hops}
+	}
+	return &httpClient
+}
@@ -0,0 +1,211 @@
+package http
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// maxTLSTransports is the number of TLS transports kept, one per logical host and deployment, each with its own
+// connection pool. Once there are more, the least recently used is closed.
+const maxTLSTransports = 64
+
+// DeploymentTLS holds the TLS settings used when connecting to the instances of a deployment.
+type DeploymentTLS struct {
+	// RootCAs is the pool of certificate authorities the instances' certificates are verified against. If nil, the
+	// transport's own root CAs are used.
+	RootCAs *x509.CertPool
+
+	// PinnedSPKIHashes are the base64 encoded SHA-256 hashes of the SubjectPublicKeyInfo the instances' leaf
+	// certificates may have. If empty, any public key is accepted.
+	PinnedSPKIHashes []string
+}
+
+var ErrSPKIPinMismatch = errors.New("certificate public key does not match any pinned key")
+
+type (
+	tlsTransportsMapKey struct {
+		host         string
+		deploymentId string
+	}
+
+	tlsTransport struct {
+		base      *Transport
+		transport *Transport
+		lastUse   uint64
+	}
+
+	// tlsTransportCache holds the TLS transports cloned by tlsTransportFor, up to maxTLSTransports. Uses are counted
+	// to know which transport was used least recently.
+	tlsTransportCache struct {
+		transports map[tlsTransportsMapKey]*tlsTransport
+		uses       uint64
+		sync.Mutex
+	}
+)
+
+// SetDeploymentTLS sets the TLS settings used to connect to the instances of the deployment with the given id. The
+// connections made with the previous settings are closed once idle.
+func (c *Client) SetDeploymentTLS(deploymentId string, deploymentTLS DeploymentTLS) {
+	c.deploymentsTLS.Store(deploymentId, deploymentTLS)
+	c.tlsTransports.removeDeployment(deploymentId)
+}
+
+// CloseIdleConnections closes the idle connections of the embedded http client and of the transports used to verify
+// TLS against the logical service names.
+func (c *Client) CloseIdleConnections() {
+	c.Client.CloseIdleConnections()
+	c.tlsTransports.closeIdleConnections()
+}
+
+// tlsTransportFor returns the transport used to send requests that were rewritten from host to one of the
+// deployment's instances. Since their URL no longer names the service, base is cloned so that the TLS server name and
+// certificate verification keep using host.
+func (c *Client) tlsTransportFor(host, deploymentId string, base RoundTripper) RoundTripper {
+	baseTransport, ok := base.(*Transport)
+	if !ok {
+		c.logger().Warn("can not verify TLS against the service name, transport is not a *Transport",
+			LogFieldHost, host, "transport", fmt.Sprintf("%T", base))
+		return base
+	}
+
+	key := tlsTransportsMapKey{host: host, deploymentId: deploymentId}
+	if transport, ok := c.tlsTransports.load(key, baseTransport); ok {
+		return transport
+	}
+
+	transport := baseTransport.Clone()
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+	transport.TLSClientConfig.ServerName = host
+
+	if value, ok := c.deploymentsTLS.Load(deploymentId); ok {
+		deploymentTLS := value.(DeploymentTLS)
+		if deploymentTLS.RootCAs != nil {
+			transport.TLSClientConfig.RootCAs = deploymentTLS.RootCAs
+		}
+
+		if len(deploymentTLS.PinnedSPKIHashes) > 0 {
+			transport.TLSClientConfig.VerifyPeerCertificate = verifySPKIPins(deploymentTLS.PinnedSPKIHashes)
+		}
+	}
+
+	if evicted := c.tlsTransports.store(key, baseTransport, transport); evicted > 0 {
+		c.logger().Debug("closed least recently used TLS transports", "count", evicted)
+	}
+	c.logger().Debug("created TLS transport", LogFieldHost, host, LogFieldDeployment, deploymentId)
+
+	return transport
+}
+
+// load returns the transport cloned from base for key, if there is one.
+func (t *tlsTransportCache) load(key tlsTransportsMapKey, base *Transport) (*Transport, bool) {
+	t.Lock()
+	defer t.Unlock()
+
+	cached, ok := t.transports[key]
+	if !ok || cached.base != base {
+		return nil, false
+	}
+
+	t.uses++
+	cached.lastUse = t.uses
+	return cached.transport, true
+}
+
+// store adds the transport cloned from base for key, closing the one it replaces, if any, and the least recently
+// used ones over maxTLSTransports. It returns how many transports were closed to keep the bound.
+func (t *tlsTransportCache) store(key tlsTransportsMapKey, base, transport *Transport) (evicted int) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.transports == nil {
+		t.transports = map[tlsTransportsMapKey]*tlsTransport{}
+	}
+
+	if replaced, ok := t.transports[key]; ok {
+		replaced.transport.CloseIdleConnections()
+	}
+	t.uses++
+	t.transports[key] = &tlsTransport{base: base, transport: transport, lastUse: t.uses}
+
+	for len(t.transports) > maxTLSTransports {
+		var (
+			oldestKey tlsTransportsMapKey
+			oldest    *tlsTransport
+		)
+		for key, cached := range t.transports {
+			if oldest == nil || cached.lastUse < oldest.lastUse {
+				oldestKey, oldest = key, cached
+			}
+		}
+
+		oldest.transport.CloseIdleConnections()
+		delete(t.transports, oldestKey)
+		evicted++
+	}
+
+	return evicted
+}
+
+// removeDeployment closes and removes the transports of the deployment with the given id.
+func (t *tlsTransportCache) removeDeployment(deploymentId string) {
+	t.Lock()
+	defer t.Unlock()
+
+	for key, cached := range t.transports {
+		if key.deploymentId == deploymentId {
+			cached.transport.CloseIdleConnections()
+			delete(t.transports, key)
+		}
+	}
+}
+
+func (t *tlsTransportCache) closeIdleConnections() {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, cached := range t.transports {
+		cached.transport.CloseIdleConnections()
+	}
+}
+
+func verifySPKIPins(pins []string) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	pinned := map[string]struct{}{}
+	for _, pin := range pins {
+		pinned[pin] = struct{}{}
+	}
+
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return ErrSPKIPinMismatch
+		}
+
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+
+		hash := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		if _, ok := pinned[base64.StdEncoding.EncodeToString(hash[:])]; !ok {
+			return fmt.Errorf("%w: %s", ErrSPKIPinMismatch, leaf.Subject)
+		}
+
+		return nil
+	}
+}
+
+// hostWithoutPort returns the host part of hostPort, which is what TLS certificates are issued for.
+func hostWithoutPort(hostPort string) string {
+	host, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return hostPort
+	}
+	return host
+}
@@ -0,0 +1,196 @@
+package http
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net"
+	originalHttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTLSTestServer returns a TLS server, whose certificate is for example.com, that tells when its connections are
+// closed.
+func newTLSTestServer(t *testing.T) (*httptest.Server, chan struct{}) {
+	closed := make(chan struct{}, 16)
+	server := httptest.NewUnstartedServer(originalHttp.HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	server.Config.ConnState = func(conn net.Conn, state originalHttp.ConnState) {
+		if state == originalHttp.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return server, closed
+}
+
+func tlsTestRoots(server *httptest.Server) *x509.CertPool {
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	return roots
+}
+
+func waitClosed(t *testing.T, closed chan struct{}) {
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+}
+
+func TestTLSTransportVerifiesLogicalHost(t *testing.T) {
+	server, _ := newTLSTestServer(t)
+	hash := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+
+	tests := []struct {
+		name    string
+		host    string
+		tls     DeploymentTLS
+		wantErr error
+	}{
+		{name: "service name", host: "example.com", tls: DeploymentTLS{RootCAs: tlsTestRoots(server)}},
+		{
+			name: "pinned key",
+			host: "example.com",
+			tls: DeploymentTLS{RootCAs: tlsTestRoots(server),
+				PinnedSPKIHashes: []string{base64.StdEncoding.EncodeToString(hash[:])}},
+		},
+		{
+			name: "other pinned key",
+			host: "example.com",
+			tls: DeploymentTLS{RootCAs: tlsTestRoots(server),
+				PinnedSPKIHashes: []string{base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))}},
+			wantErr: ErrSPKIPinMismatch,
+		},
+		{name: "wrong service name", host: "other.com", tls: DeploymentTLS{RootCAs: tlsTestRoots(server)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := &Client{}
+			c.SetDeploymentTLS("d", test.tls)
+			defer c.CloseIdleConnections()
+
+			transport := c.tlsTransportFor(test.host, "d", &Transport{})
+			req, err := NewRequest("GET", server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := transport.RoundTrip(req)
+			if resp != nil {
+				_ = resp.Body.Close()
+			}
+
+			switch {
+			case test.host != "example.com":
+				if err == nil {
+					t.Error("got no error for a certificate of another host")
+				}
+			case test.wantErr != nil:
+				if !errors.Is(err, test.wantErr) {
+					t.Errorf("got %v, want %v", err, test.wantErr)
+				}
+			case err != nil:
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestTLSTransportsCloseIdleConnections(t *testing.T) {
+	server, closed := newTLSTestServer(t)
+	c := &Client{}
+	c.SetDeploymentTLS("d", DeploymentTLS{RootCAs: tlsTestRoots(server)})
+
+	base := &Transport{}
+	get := func() {
+		req, err := NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := c.tlsTransportFor("example.com", "d", base).RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+	}
+
+	get()
+	c.CloseIdleConnections()
+	waitClosed(t, closed)
+
+	get()
+	c.SetDeploymentTLS("d", DeploymentTLS{RootCAs: tlsTestRoots(server)})
+	waitClosed(t, closed)
+	if len(c.tlsTransports.transports) != 0 {
+		t.Errorf("got %d transports after changing the deployment TLS, want 0", len(c.tlsTransports.transports))
+	}
+}
+
+func TestTLSTransportsAreBounded(t *testing.T) {
+	c := &Client{}
+	base := &Transport{}
+
+	first := c.tlsTransportFor("svc0", "d", base)
+	for i := 1; i <= maxTLSTransports; i++ {
+		c.tlsTransportFor("svc"+strconv.Itoa(i), "d", base)
+		// keep the first transport in use so that the second is the least recently used
+		if got := c.tlsTransportFor("svc0", "d", base); got != first {
+			t.Fatal("got a new transport for a cached host")
+		}
+	}
+
+	if got := len(c.tlsTransports.transports); got != maxTLSTransports {
+		t.Errorf("got %d transports, want %d", got, maxTLSTransports)
+	}
+	if _, ok := c.tlsTransports.transports[tlsTransportsMapKey{host: "svc1", deploymentId: "d"}]; ok {
+		t.Error("least recently used transport was kept")
+	}
+	if _, ok := c.tlsTransports.transports[tlsTransportsMapKey{host: "svc0", deploymentId: "d"}]; !ok {
+		t.Error("recently used transport was closed")
+	}
+
+	if got := c.tlsTransportFor("svc0", "d", &Transport{}); got == first {
+		t.Error("got the transport cloned from another base transport")
+	}
+}
+
+func TestDeploymentTLSWithEndpoint(t *testing.T) {
+	server, _ := newTLSTestServer(t)
+	endpoint := server.Listener.Addr().String()
+
+	c := newFakeArchimedesClient(&fakeArchimedes{endpoints: map[string]string{}})
+	c.DeploymentIDFunc = DeploymentIDMap(map[string]string{"example.com": "d"})
+	c.SetDeploymentTLS("d", DeploymentTLS{RootCAs: tlsTestRoots(server)})
+	defer c.CloseIdleConnections()
+
+	req, err := NewRequestWithContext(WithEndpoint(context.Background(), endpoint), "GET", "https://example.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+
+	// hosts without a deployment have no deployment TLS, but can still be sent to an explicit endpoint
+	req, err = NewRequestWithContext(WithEndpoint(context.Background(), endpoint), "GET", "https://other.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err = c.Do(req); err == nil {
+		_ = resp.Body.Close()
+		t.Error("got no error for a certificate of an unknown authority")
+	} else if errors.Is(err, ErrNoDeploymentID) {
+		t.Errorf("got %v for a request to an explicit endpoint", err)
+	}
+}
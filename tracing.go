@@ -0,0 +1,121 @@
+package http
+
+import (
+	"context"
+)
+
+// Tracer records the spans of the requests done by a Client, so that tracing backends can be plugged in without the
+// client depending on them. The oteltrace package has a Tracer for OpenTelemetry.
+//
+// Each Do call produces a SpanDo span with a SpanCacheLookup child, a SpanResolve child when the service is resolved
+// in archimedes and a SpanUpstream child for the request sent to the resolved endpoint.
+type Tracer interface {
+	// Start starts a span named name, as a child of the span in ctx if there is one, and returns a copy of ctx with
+	// it. Attributes are given as alternating keys and values, like in Logger.
+	Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, Span)
+
+	// Inject adds the headers that propagate the span in ctx, like W3C traceparent, to header.
+	Inject(ctx context.Context, header Header)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	SetAttributes(keysAndValues ...interface{})
+	AddEvent(name string, keysAndValues ...interface{})
+
+	// End ends the span, recording err in it if there was one.
+	End(err error)
+}
+
+// Span names.
+const (
+	SpanDo          = "archimedes.Do"
+	SpanCacheLookup = "archimedes.cache_lookup"
+	SpanResolve     = "archimedes.resolve"
+	SpanUpstream    = "archimedes.upstream"
+)
+
+// Span events. EventRetry is added to SpanResolve whenever archimedes times out and the resolution is retried,
+// EventRedirected when archimedes redirects the resolution to a closer archimedes node and EventAdopted when that
+// node becomes the client's archimedes server.
+const (
+	EventRetry      = "archimedes.retry"
+	EventRedirected = "archimedes.redirected"
+	EventAdopted    = "archimedes.adopted"
+)
+
+// Span attributes. SpanDo has AttrHttpMethod, AttrUrl and AttrCell when started and AttrDeploymentId,
+// AttrCacheStatus, AttrEndpoint and AttrHttpStatusCode when it ends. SpanCacheLookup has AttrCacheStatus, SpanResolve
+// has AttrDeploymentId, AttrArchimedesServer, AttrCell and AttrEndpoint and SpanUpstream has AttrEndpoint and
+// AttrHttpStatusCode.
+const (
+	AttrDeploymentId     = "archimedes.deployment_id"
+	AttrEndpoint         = "archimedes.endpoint"
+	AttrCell             = "archimedes.client.s2_cell"
+	AttrCacheStatus      = "archimedes.cache_status"
+	AttrArchimedesServer = "archimedes.server"
+
+	AttrHttpMethod     = "http.request.method"
+	AttrHttpStatusCode = "http.response.status_code"
+	AttrUrl            = "url.full"
+)
+
+type spanContextKey struct{}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...interface{})    {}
+func (noopSpan) AddEvent(string, ...interface{}) {}
+func (noopSpan) End(error)                       {}
+
+func (c *Client) tracingEnabled() bool {
+	return c.Tracer != nil
+}
+
+// startSpan starts a span with the client's Tracer, if it has one, keeping it in the returned context so that it can
+// be found by spanFromContext.
+func (c *Client) startSpan(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, Span) {
+	if !c.tracingEnabled() {
+		return ctx, noopSpan{}
+	}
+
+	ctx, span := c.Tracer.Start(ctx, name, keysAndValues...)
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// spanFromContext returns the span started by startSpan that is in ctx, or a span that records nothing.
+func spanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanContextKey{}).(Span); ok {
+		return span
+	}
+	return noopSpan{}
+}
+
+// injectTraceContext adds the headers that propagate the span in ctx to req.
+func (c *Client) injectTraceContext(ctx context.Context, req *Request) {
+	if !c.tracingEnabled() {
+		return
+	}
+
+	if req.Header == nil {
+		req.Header = Header{}
+	}
+	c.Tracer.Inject(ctx, req.Header)
+}
+
+// endDoSpan ends the span of a whole Client.Do call with what is known about the request once it finished.
+func endDoSpan(span Span, info *RequestInfo) {
+	keysAndValues := []interface{}{
+		AttrDeploymentId, info.DeploymentId,
+		AttrCacheStatus, info.CacheStatus.String(),
+	}
+	if info.ResolvedURL != nil {
+		keysAndValues = append(keysAndValues, AttrEndpoint, info.ResolvedURL.Host)
+	}
+	if info.StatusCode != 0 {
+		keysAndValues = append(keysAndValues, AttrHttpStatusCode, info.StatusCode)
+	}
+
+	span.SetAttributes(keysAndValues...)
+	span.End(info.Err)
+}
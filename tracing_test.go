@@ -0,0 +1,290 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	originalHttp "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	archimedes "github.com/bruno-anjos/archimedesHTTPClient"
+	"github.com/bruno-anjos/archimedesHTTPClient/oteltrace"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, attr := range span.Attributes() {
+		attrs[attr.Key] = attr.Value
+	}
+	return attrs
+}
+
+func checkAttributes(t *testing.T, span sdktrace.ReadOnlySpan, want map[string]interface{}) {
+	t.Helper()
+
+	attrs := spanAttributes(span)
+	for key, wantValue := range want {
+		value, ok := attrs[attribute.Key(key)]
+		switch {
+		case !ok:
+			t.Errorf("%s has no %s", span.Name(), key)
+		case wantValue != nil && fmt.Sprint(value.AsInterface()) != fmt.Sprint(wantValue):
+			t.Errorf("%s has %s %v, want %v", span.Name(), key, value.AsInterface(), wantValue)
+		}
+	}
+}
+
+func newTracedClient(t *testing.T) (*archimedes.Client, *tracetest.SpanRecorder, *httptest.Server,
+	chan originalHttp.Header) {
+	headers := make(chan originalHttp.Header, 1)
+	server := httptest.NewServer(originalHttp.HandlerFunc(func(w archimedes.ResponseWriter, r *archimedes.Request) {
+		headers <- r.Header.Clone()
+	}))
+	t.Cleanup(server.Close)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() { _ = provider.Shutdown(context.Background()) })
+
+	c := archimedes.NewFakeArchimedesClient(map[string]string{"svc": server.Listener.Addr().String()})
+	c.Tracer = oteltrace.New(provider, nil)
+
+	return c, recorder, server, headers
+}
+
+func TestDoSpans(t *testing.T) {
+	c, recorder, server, headers := newTracedClient(t)
+	endpoint := server.Listener.Addr().String()
+
+	resp, err := c.Get("http://svc/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	header := <-headers
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	doSpan, ok := spans[archimedes.SpanDo]
+	if !ok {
+		t.Fatalf("no %s span in %v", archimedes.SpanDo, spans)
+	}
+	if doSpan.Parent().IsValid() {
+		t.Errorf("%s has a parent", archimedes.SpanDo)
+	}
+	checkAttributes(t, doSpan, map[string]interface{}{
+		archimedes.AttrHttpMethod:     "GET",
+		archimedes.AttrUrl:            "http://svc/path",
+		archimedes.AttrCell:           nil,
+		archimedes.AttrDeploymentId:   "svc",
+		archimedes.AttrCacheStatus:    "miss",
+		archimedes.AttrEndpoint:       endpoint,
+		archimedes.AttrHttpStatusCode: archimedes.StatusOK,
+	})
+
+	children := map[string]map[string]interface{}{
+		archimedes.SpanCacheLookup: {
+			archimedes.AttrCacheStatus: "miss",
+		},
+		archimedes.SpanResolve: {
+			archimedes.AttrDeploymentId:     "svc",
+			archimedes.AttrArchimedesServer: "archimedes:1500",
+			archimedes.AttrCell:             nil,
+			archimedes.AttrEndpoint:         endpoint,
+		},
+		archimedes.SpanUpstream: {
+			archimedes.AttrEndpoint:       endpoint,
+			archimedes.AttrHttpStatusCode: archimedes.StatusOK,
+		},
+	}
+	for name, attrs := range children {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("no %s span", name)
+			continue
+		}
+
+		if span.Parent().SpanID() != doSpan.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of %s", name, archimedes.SpanDo)
+		}
+		checkAttributes(t, span, attrs)
+	}
+
+	if kind := spans[archimedes.SpanUpstream].SpanKind(); kind != trace.SpanKindClient {
+		t.Errorf("%s has kind %s, want %s", archimedes.SpanUpstream, kind, trace.SpanKindClient)
+	}
+
+	upstream := spans[archimedes.SpanUpstream].SpanContext()
+	want := fmt.Sprintf("00-%s-%s-01", upstream.TraceID(), upstream.SpanID())
+	if got := header.Get("Traceparent"); got != want {
+		t.Errorf("got traceparent %q, want %q", got, want)
+	}
+}
+
+func TestDoSpansCacheHit(t *testing.T) {
+	c, recorder, _, headers := newTracedClient(t)
+
+	for i := 0; i < 2; i++ {
+		resp, err := c.Get("http://svc/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		<-headers
+	}
+
+	var resolves int
+	var doSpan sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		switch span.Name() {
+		case archimedes.SpanResolve:
+			resolves++
+		case archimedes.SpanDo:
+			doSpan = span
+		}
+	}
+
+	if resolves != 1 {
+		t.Errorf("got %d %s spans, want 1", resolves, archimedes.SpanResolve)
+	}
+	checkAttributes(t, doSpan, map[string]interface{}{archimedes.AttrCacheStatus: "hit"})
+}
+
+func TestDoSpanError(t *testing.T) {
+	c, recorder, _, _ := newTracedClient(t)
+	c.DeploymentIDFunc = archimedes.DeploymentIDMap(map[string]string{})
+
+	if _, err := c.Get("http://svc/"); !errors.Is(err, archimedes.ErrNoDeploymentID) {
+		t.Fatalf("got %v, want ErrNoDeploymentID", err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != archimedes.SpanDo {
+		t.Fatalf("got %d spans, want only %s", len(spans), archimedes.SpanDo)
+	}
+	if status := spans[0].Status(); status.Code != codes.Error {
+		t.Errorf("got status %v, want an error", status)
+	}
+}
+
+// traceRecorder records the hooks of the ArchimedesTraces it returns that were called, in order, with their info.
+type traceRecorder struct {
+	events []string
+	sync.Mutex
+}
+
+func (r *traceRecorder) record(name, hook string, info interface{}) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, fmt.Sprintf("%s %s %+v", name, hook, info))
+}
+
+// trace returns an ArchimedesTrace whose events are recorded with name.
+func (r *traceRecorder) trace(name string) *archimedes.ArchimedesTrace {
+	return &archimedes.ArchimedesTrace{
+		ResolveStart: func(info archimedes.ResolveStartInfo) { r.record(name, "ResolveStart", info) },
+		ResolveDone: func(info archimedes.ResolveDoneInfo) {
+			// errors are compared by their message
+			err := info.Err
+			info.Err = nil
+			r.record(name, "ResolveDone", fmt.Sprintf("%+v %v", info, err))
+		},
+		CacheHit: func(info archimedes.CacheHitInfo) { r.record(name, "CacheHit", info) },
+	}
+}
+
+func (r *traceRecorder) take() []string {
+	r.Lock()
+	defer r.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func TestArchimedesTraceHooks(t *testing.T) {
+	c, _, server, headers := newTracedClient(t)
+	endpoint := server.Listener.Addr().String()
+
+	recorder := &traceRecorder{}
+	c.ArchimedesTrace = recorder.trace("client")
+
+	tests := []struct {
+		name    string
+		url     string
+		timeOut bool
+		wantErr bool
+		want    []string
+	}{
+		{
+			name: "resolved",
+			url:  "http://svc/",
+			want: []string{
+				"ResolveStart {Host:svc:80}",
+				"ResolveDone {Host:svc:80 Endpoint:" + endpoint + " Status:found Cached:false Err:<nil>} <nil>",
+			},
+		},
+		{
+			name: "cached",
+			url:  "http://svc/",
+			want: []string{
+				"ResolveStart {Host:svc:80}",
+				"CacheHit {Host:svc:80 Endpoint:" + endpoint + " Stale:false Pinned:false}",
+				"ResolveDone {Host:svc:80 Endpoint:" + endpoint + " Status:found Cached:true Err:<nil>} <nil>",
+			},
+		},
+		{
+			name:    "timed out",
+			url:     "http://other-svc/",
+			timeOut: true,
+			wantErr: true,
+			want: []string{
+				"ResolveStart {Host:other-svc:80}",
+				"ResolveDone {Host:other-svc:80 Endpoint: Status:error Cached:false Err:<nil>} " +
+					"resolution timed out: resolving other-svc:80: context deadline exceeded",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			archimedes.SetFakeArchimedesTimeOut(c, test.timeOut)
+
+			ctx := archimedes.WithArchimedesTrace(context.Background(), recorder.trace("request"))
+			ctx = archimedes.WithResolutionTimeout(ctx, 50*time.Millisecond)
+			req, err := archimedes.NewRequestWithContext(ctx, "GET", test.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := c.Do(req)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %t", err, test.wantErr)
+			}
+			if err == nil {
+				_ = resp.Body.Close()
+				<-headers
+			}
+
+			// every hook of the request trace is called before the same hook of the client trace
+			var want []string
+			for _, event := range test.want {
+				want = append(want, "request "+event, "client "+event)
+			}
+			if got := recorder.take(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+				t.Errorf("got events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
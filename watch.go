@@ -0,0 +1,153 @@
+package http
+
+import (
+	"context"
+)
+
+// ResolutionChangeReason is the reason why archimedes would now resolve a service to a different endpoint.
+type ResolutionChangeReason int
+
+const (
+	// ReasonExpired means the cached resolution expired and archimedes resolved the service again.
+	ReasonExpired ResolutionChangeReason = iota
+	// ReasonLocationChanged means the client changed location and archimedes resolved the service again.
+	ReasonLocationChanged
+	// ReasonFailover means the endpoint being used failed and archimedes resolved the service again.
+	ReasonFailover
+	// ReasonInvalidated means archimedes invalidated the cached resolution, usually because the deployment moved.
+	ReasonInvalidated
+)
+
+func (r ResolutionChangeReason) String() string {
+	switch r {
+	case ReasonExpired:
+		return "expired"
+	case ReasonLocationChanged:
+		return "location changed"
+	case ReasonFailover:
+		return "failover"
+	case ReasonInvalidated:
+		return "invalidated"
+	default:
+		return "unknown"
+	}
+}
+
+// ResolutionChange describes a change in the endpoint a service resolves to.
+type ResolutionChange struct {
+	Host   string
+	Old    string
+	New    string
+	Reason ResolutionChangeReason
+}
+
+// watchChanSize is the number of changes buffered for each watcher. Changes that do not fit are dropped, since the
+// client never blocks waiting for a watcher.
+const watchChanSize = 8
+
+// Watch returns a channel that receives a ResolutionChange every time the service at host resolves to a different
+// endpoint than before. Long-lived connections (websockets, streams, server sent events) should use it to reconnect
+// at a safe point, as described in the Client documentation. The channel is closed when ctx is done.
+//
+// The service can be given as a host:port, a URL or a bare host, which uses the default http port, e.g. svc:8080,
+// https://svc or svc. The changes name it as host:port.
+func (c *Client) Watch(ctx context.Context, host string) <-chan ResolutionChange {
+	changes := make(chan ResolutionChange, watchChanSize)
+	host = c.serviceHostPort(host)
+
+	c.watchersLock.Lock()
+	if c.watchers == nil {
+		c.watchers = map[string]map[chan ResolutionChange]struct{}{}
+	}
+	if c.watchers[host] == nil {
+		c.watchers[host] = map[chan ResolutionChange]struct{}{}
+	}
+	c.watchers[host][changes] = struct{}{}
+	c.watchersLock.Unlock()
+
+	c.logger().Debug("watching", LogFieldHost, host)
+
+	go func() {
+		<-ctx.Done()
+
+		c.watchersLock.Lock()
+		delete(c.watchers[host], changes)
+		if len(c.watchers[host]) == 0 {
+			delete(c.watchers, host)
+		}
+		close(changes)
+		c.watchersLock.Unlock()
+
+		c.logger().Debug("stopped watching", LogFieldHost, host)
+	}()
+
+	return changes
+}
+
+func (c *Client) isWatched(hostPort string) bool {
+	c.watchersLock.Lock()
+	defer c.watchersLock.Unlock()
+	return len(c.watchers[hostPort]) > 0
+}
+
+func (c *Client) watchedHosts() []string {
+	c.watchersLock.Lock()
+	defer c.watchersLock.Unlock()
+
+	hosts := make([]string, 0, len(c.watchers))
+	for hostPort := range c.watchers {
+		hosts = append(hosts, hostPort)
+	}
+	return hosts
+}
+
+// notifyResolutionChange sends a ResolutionChange to the watchers of the service at hostPort, unless it was not
+// resolved before or resolves to the same endpoint.
+func (c *Client) notifyResolutionChange(hostPort, oldResolved, newResolved string, reason ResolutionChangeReason) {
+	if oldResolved == "" || oldResolved == newResolved {
+		return
+	}
+
+	change := ResolutionChange{
+		Host:   hostPort,
+		Old:    oldResolved,
+		New:    newResolved,
+		Reason: reason,
+	}
+
+	c.watchersLock.Lock()
+	defer c.watchersLock.Unlock()
+
+	for changes := range c.watchers[hostPort] {
+		select {
+		case changes <- change:
+		default:
+			c.logger().Warn("dropped resolution change, watcher is not keeping up", LogFieldHost, hostPort,
+				"old_endpoint", oldResolved, LogFieldEndpoint, newResolved)
+		}
+	}
+}
+
+// reresolveWatched resolves a watched service again in archimedes, replacing its cache entry, and notifies its
+// watchers if the resulting endpoint differs from oldResolved.
+func (c *Client) reresolveWatched(hostPort, oldResolved string, reason ResolutionChangeReason) {
+	opts := c.resolveOptionsFor(context.Background(), "")
+	cacheKey := c.cacheKey(hostPort, opts.location)
+	if value, ok := c.cache.Load(cacheKey); ok {
+		opts = c.resolveOptionsForEntry(value.(addressCacheValue))
+	}
+	c.cache.Delete(cacheKey)
+
+	newResolved, found, err := c.resolveServiceInArchimedes(context.Background(), hostPort, opts)
+	if err != nil {
+		c.logger().Error("error resolving watched host", LogFieldHost, hostPort, LogFieldError, err)
+		return
+	}
+
+	if !found {
+		c.logger().Info("could not resolve watched host", LogFieldHost, hostPort)
+		return
+	}
+
+	c.notifyResolutionChange(hostPort, oldResolved, newResolved, reason)
+}
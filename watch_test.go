@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestWatchNormalizesHost(t *testing.T) {
+	for _, host := range []string{"svc", "svc:80", "http://svc/"} {
+		t.Run(host, func(t *testing.T) {
+			c := &Client{}
+			ctx, cancel := context.WithCancel(context.Background())
+			changes := c.Watch(ctx, host)
+
+			c.notifyResolutionChange("svc:80", "10.0.0.1:80", "10.0.0.2:80", ReasonFailover)
+
+			select {
+			case change := <-changes:
+				if change.Host != "svc:80" || change.New != "10.0.0.2:80" {
+					t.Errorf("got %+v", change)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("watcher was not notified")
+			}
+
+			cancel()
+			if _, ok := <-changes; ok {
+				t.Error("channel was not closed when the context was done")
+			}
+			if c.isWatched("svc:80") {
+				t.Error("still watched after the context was done")
+			}
+		})
+	}
+}
+
+func TestNotifyResolutionChangeSkipsSameEndpoint(t *testing.T) {
+	c := &Client{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := c.Watch(ctx, "svc:80")
+
+	c.notifyResolutionChange("svc:80", "10.0.0.1:80", "10.0.0.1:80", ReasonExpired)
+
+	select {
+	case change := <-changes:
+		t.Errorf("got %+v for an unchanged endpoint", change)
+	default:
+	}
+}
+
+func TestNotifyResolutionChangeSkipsFirstResolution(t *testing.T) {
+	c := &Client{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := c.Watch(ctx, "svc:80")
+
+	c.notifyResolutionChange("svc:80", "", "10.0.0.1:80", ReasonLocationChanged)
+
+	select {
+	case change := <-changes:
+		t.Errorf("got %+v for a service that was not resolved before", change)
+	default:
+	}
+}
+
+// waitForResolutions waits until archimedes resolved n services.
+func waitForResolutions(t *testing.T, archimedes *fakeArchimedes, n int) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); len(archimedes.resolved()) < n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d resolutions, want %d", len(archimedes.resolved()), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSetLocationReresolvesWatchedOnCellChange(t *testing.T) {
+	archimedes := &fakeArchimedes{endpoints: map[string]string{"svc": "10.0.0.1:80"}}
+	c := newFakeArchimedesClient(archimedes)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := c.Watch(ctx, "svc")
+
+	c.SetLocation(s2.LatLngFromDegrees(38.7369, -9.1427))
+	waitForResolutions(t, archimedes, 1)
+
+	// moves of about 10cm stay in the same cell
+	for i := 1; i <= 3; i++ {
+		c.SetLocation(s2.LatLngFromDegrees(38.7369+float64(i)*1e-6, -9.1427))
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := len(archimedes.resolved()); got != 1 {
+		t.Errorf("got %d resolutions after small moves, want 1", got)
+	}
+
+	archimedes.Lock()
+	archimedes.endpoints = map[string]string{"svc": "10.0.0.2:80"}
+	archimedes.Unlock()
+
+	c.SetLocation(s2.LatLngFromDegrees(41.1579, -8.6291))
+	waitForResolutions(t, archimedes, 2)
+
+	select {
+	case change := <-changes:
+		want := ResolutionChange{Host: "svc:80", Old: "10.0.0.1:80", New: "10.0.0.2:80",
+			Reason: ReasonLocationChanged}
+		if change != want {
+			t.Errorf("got %+v, want %+v", change, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher was not notified of the move to another cell")
+	}
+
+	select {
+	case change := <-changes:
+		t.Errorf("got unexpected %+v", change)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,34 @@
+// Package zaplog adapts zap loggers to the Logger of an archimedes http.Client.
+//
+//	client.Logger = zaplog.New(logger)
+package zaplog
+
+import (
+	archimedes "github.com/bruno-anjos/archimedesHTTPClient"
+	"go.uber.org/zap"
+)
+
+type zapLogger struct {
+	logger *zap.SugaredLogger
+}
+
+// New adapts a zap logger to an archimedes http.Logger. The structured fields become zap fields.
+func New(logger *zap.Logger) archimedes.Logger {
+	return &zapLogger{logger: logger.WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+func (l *zapLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Debugw(msg, keysAndValues...)
+}
+
+func (l *zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Infow(msg, keysAndValues...)
+}
+
+func (l *zapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warnw(msg, keysAndValues...)
+}
+
+func (l *zapLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Errorw(msg, keysAndValues...)
+}
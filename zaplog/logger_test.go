@@ -0,0 +1,37 @@
+package zaplog
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	logger := New(zap.New(core))
+
+	logger.Debug("debug", "host", "svc:80")
+	logger.Info("info")
+	logger.Warn("warn", "attempt", 2)
+	logger.Error("error")
+
+	entries := logs.AllUntimed()
+	want := []zapcore.Level{zapcore.DebugLevel, zapcore.InfoLevel, zapcore.WarnLevel, zapcore.ErrorLevel}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i, entry := range entries {
+		if entry.Level != want[i] {
+			t.Errorf("entry %d has level %s, want %s", i, entry.Level, want[i])
+		}
+	}
+
+	if fields := entries[0].ContextMap(); fields["host"] != "svc:80" {
+		t.Errorf("got fields %v, want host svc:80", fields)
+	}
+	if fields := entries[2].ContextMap(); fields["attempt"] != int64(2) {
+		t.Errorf("got fields %v, want attempt 2", fields)
+	}
+}